@@ -0,0 +1,160 @@
+package adaptive
+
+// Holds the server at a latency goal for as long as you like, for soak
+// tests. It's AIMD like TCP congestion control: every interval, if the
+// p95 is under the goal it adds a worker, if it's over (or the errors
+// are) it cuts the workers by a fraction. Until the first cut it doubles
+// instead of adding one, so it doesn't take all afternoon to get going.
+//
+// Like the capacity search, it only says how many workers it wants on
+// targets, the main program does the rest.
+
+import (
+	"fmt"
+	"log"
+
+	stats "github.com/kgoess/webserver-loadtest/stats"
+)
+
+type Config struct {
+	TargetP95Ms  float64
+	MaxErrorPct  float64 // over this counts as congestion too
+	Min          int
+	Max          int
+	IntervalSecs int         // how many seconds to measure before each adjustment
+	Decrease     float64     // multiply the workers by this when over, e.g. 0.75
+	Paused       func() bool // the seconds when this is true don't count, nil for never
+}
+
+type Controller struct {
+	cfg       Config
+	snapshots chan stats.Snapshot
+	targets   chan<- int
+	msgs      chan<- string
+	slowStart bool
+	info      *log.Logger // its own, not a package INFO, so the tests' controllers don't share one
+}
+
+func MakeNew(cfg Config, targets chan<- int, msgs chan<- string, infoLog *log.Logger) *Controller {
+	if cfg.Min < 1 {
+		cfg.Min = 1
+	}
+	if cfg.Max < cfg.Min {
+		cfg.Max = cfg.Min
+	}
+	if cfg.IntervalSecs < 1 {
+		cfg.IntervalSecs = 1
+	}
+	if cfg.Decrease <= 0 || cfg.Decrease >= 1 {
+		cfg.Decrease = 0.75
+	}
+	return &Controller{
+		cfg:       cfg,
+		snapshots: make(chan stats.Snapshot, 10),
+		targets:   targets,
+		msgs:      msgs,
+		slowStart: true,
+		info:      infoLog,
+	}
+}
+
+// Report is called from the display loop, so it mustn't block
+func (c *Controller) Report(snap stats.Snapshot) {
+	select {
+	case c.snapshots <- snap:
+	default:
+		c.info.Println("adaptive controller is behind, dropping a snapshot")
+	}
+}
+
+func (c *Controller) Run() {
+	workers := c.cfg.Min
+	c.msgs <- fmt.Sprintf("holding p95 at %.0fms, starting with %d workers", c.cfg.TargetP95Ms, workers)
+	c.targets <- workers
+
+	var p95s []float64
+	var secs int
+	var reqs, fails, unanswered int64
+	settled := false
+	for snap := range c.snapshots {
+		if snap.Workers != workers {
+			continue
+		}
+		if !settled {
+			// the first second after a change is part ramp-up
+			settled = true
+			continue
+		}
+		if c.cfg.Paused != nil && c.cfg.Paused() {
+			// nothing to go on
+			continue
+		}
+		// a second where nothing finished has a p95 of 0, which
+		// isn't fast, so it stays out of the average
+		secs++
+		if snap.ReqSec > 0 {
+			p95s = append(p95s, snap.LatencyP95Ms)
+		}
+		reqs += snap.ReqSec
+		fails += snap.Fails
+		unanswered += snap.Unanswered
+		if secs < c.cfg.IntervalSecs {
+			continue
+		}
+
+		p95 := mean(p95s)
+		errorPct := stats.ErrorPct(reqs, fails, unanswered)
+		if reqs+unanswered == 0 {
+			// a whole interval with nothing finishing, the server's
+			// hung, that's as congested as it gets
+			errorPct = 100
+		}
+		p95s, secs, reqs, fails, unanswered = p95s[:0], 0, 0, 0, 0
+		settled = false
+
+		next := c.next(workers, p95, errorPct)
+		if next < workers {
+			c.msgs <- fmt.Sprintf("p95 %.0fms, %.1f%% errors, backing off to %d workers", p95, errorPct, next)
+		}
+		c.info.Printf("adaptive: p95 %.0fms, %.1f%% errors at %d workers, going to %d", p95, errorPct, workers, next)
+		if next != workers {
+			workers = next
+			c.targets <- workers
+		}
+	}
+}
+
+// the AIMD part
+func (c *Controller) next(workers int, p95 float64, errorPct float64) int {
+	if p95 > c.cfg.TargetP95Ms || errorPct > c.cfg.MaxErrorPct {
+		c.slowStart = false
+		next := int(float64(workers) * c.cfg.Decrease)
+		if next >= workers {
+			next = workers - 1
+		}
+		if next < c.cfg.Min {
+			next = c.cfg.Min
+		}
+		return next
+	}
+
+	next := workers + 1
+	if c.slowStart {
+		next = workers * 2
+	}
+	if next > c.cfg.Max {
+		next = c.cfg.Max
+	}
+	return next
+}
+
+func mean(vals []float64) float64 {
+	if len(vals) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range vals {
+		sum += v
+	}
+	return sum / float64(len(vals))
+}
@@ -0,0 +1,150 @@
+package adaptive
+
+import (
+	"io/ioutil"
+	"log"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	stats "github.com/kgoess/webserver-loadtest/stats"
+)
+
+var testConfig = Config{
+	TargetP95Ms:  200,
+	MaxErrorPct:  1,
+	Min:          1,
+	Max:          100,
+	IntervalSecs: 2,
+	Decrease:     0.5,
+}
+
+func TestAIMD(t *testing.T) {
+	c := MakeNew(testConfig, nil, nil, log.New(ioutil.Discard, "", 0))
+
+	// doubles until the first time it's over
+	for _, tc := range []struct {
+		workers  int
+		p95      float64
+		errorPct float64
+		want     int
+	}{
+		{1, 50, 0, 2},
+		{2, 50, 0, 4},
+		{64, 50, 0, 100}, // capped at Max
+		{40, 250, 0, 20},
+		{20, 50, 0, 21}, // just adding one now
+		{21, 50, 5, 10}, // errors count too
+		{1, 500, 0, 1},  // but never under Min
+	} {
+		if got := c.next(tc.workers, tc.p95, tc.errorPct); got != tc.want {
+			t.Errorf("%d workers with p95 %.0f and %.0f%% errors s/b going to %d, got %d",
+				tc.workers, tc.p95, tc.errorPct, tc.want, got)
+		}
+	}
+}
+
+// a server whose p95 is 10ms per worker, so 20 workers is the goal
+func TestControllerRun(t *testing.T) {
+	cfg := testConfig
+	cfg.Decrease = 0.9
+	targets := make(chan int)
+	msgs := make(chan string, 100)
+	c := MakeNew(cfg, targets, msgs, log.New(ioutil.Discard, "", 0))
+	go c.Run()
+
+	var seen []int
+	timeout := time.After(5 * time.Second)
+	for len(seen) < 30 {
+		select {
+		case workers := <-targets:
+			seen = append(seen, workers)
+			for i := 0; i <= cfg.IntervalSecs; i++ {
+				c.Report(stats.Snapshot{Workers: workers, ReqSec: 100, LatencyP95Ms: float64(workers * 10)})
+			}
+		case <-timeout:
+			t.Fatalf("controller stopped adjusting, saw %v", seen)
+		}
+	}
+	// it saws up and down, but around the goal
+	for _, workers := range seen[10:] {
+		if workers < 15 || workers > 21 {
+			t.Errorf("s/b hovering around 20 workers, saw %v", seen)
+			break
+		}
+	}
+}
+
+// reports one interval's worth of snap at workers (plus the one to
+// settle) and returns where the controller goes next, 0 if it doesn't
+func interval(t *testing.T, c *Controller, targets <-chan int, workers int, snap stats.Snapshot) int {
+	snap.Workers = workers
+	for i := 0; i <= c.cfg.IntervalSecs; i++ {
+		c.Report(snap)
+	}
+	select {
+	case next := <-targets:
+		return next
+	case <-time.After(200 * time.Millisecond):
+		return 0
+	}
+}
+
+func TestControllerBacksOff(t *testing.T) {
+	var paused int32
+	cfg := testConfig
+	cfg.Paused = func() bool { return atomic.LoadInt32(&paused) != 0 }
+	targets := make(chan int)
+	c := MakeNew(cfg, targets, make(chan string, 100), log.New(ioutil.Discard, "", 0))
+	go c.Run()
+
+	fine := stats.Snapshot{ReqSec: 100, LatencyP95Ms: 50}
+	workers := <-targets
+	for workers < 8 {
+		workers = interval(t, c, targets, workers, fine)
+	}
+
+	// every response a 500, they're in the requests and the fails both
+	if next := interval(t, c, targets, workers, stats.Snapshot{ReqSec: 100, Fails: 100, LatencyP95Ms: 5}); next != workers/2 {
+		t.Errorf("all 500s s/b backing off from %d to %d, got %d", workers, workers/2, next)
+	}
+	workers /= 2
+
+	// paused, so nothing's happening and that's fine
+	atomic.StoreInt32(&paused, 1)
+	if next := interval(t, c, targets, workers, stats.Snapshot{}); next != 0 {
+		t.Errorf("paused s/b staying put, went to %d", next)
+	}
+	atomic.StoreInt32(&paused, 0)
+
+	// hung, nothing finishing at all
+	if next := interval(t, c, targets, workers, stats.Snapshot{}); next != workers/2 {
+		t.Errorf("a hung server s/b backing off from %d to %d, got %d", workers, workers/2, next)
+	}
+}
+
+// the seconds where nothing finished don't drag the p95 down
+func TestControllerStalls(t *testing.T) {
+	targets := make(chan int)
+	c := MakeNew(testConfig, targets, make(chan string, 100), log.New(ioutil.Discard, "", 0))
+	go c.Run()
+
+	workers := <-targets
+	for workers < 8 {
+		workers = interval(t, c, targets, workers, stats.Snapshot{ReqSec: 100, LatencyP95Ms: 50})
+	}
+
+	// the settling second, then one slow second and one with nothing
+	// coming back, which would average out under the goal
+	c.Report(stats.Snapshot{Workers: workers, ReqSec: 100, LatencyP95Ms: 300})
+	c.Report(stats.Snapshot{Workers: workers, ReqSec: 100, LatencyP95Ms: 300})
+	c.Report(stats.Snapshot{Workers: workers})
+	select {
+	case next := <-targets:
+		if next != workers/2 {
+			t.Errorf("a stalling server s/b backing off from %d to %d, got %d", workers, workers/2, next)
+		}
+	case <-time.After(200 * time.Millisecond):
+		t.Errorf("a stalling server s/b backing off from %d, stayed put", workers)
+	}
+}
@@ -0,0 +1,215 @@
+package capacity
+
+// Finds the most workers the server can take while still meeting the SLO,
+// so you don't have to sit there pressing + until it smokes. It steps the
+// worker count up, holds each level for a while, and when a level breaks
+// the p99 or error limits it backs off and tries halfway in between, until
+// there's nothing left between the best level that passed and the lowest
+// one that didn't.
+//
+// It doesn't touch the requesters itself, it just sends the worker counts
+// it wants on targets and the main program makes it so.
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	stats "github.com/kgoess/webserver-loadtest/stats"
+)
+
+// debugging kludge--is this really the way to share global loggers?
+var (
+	INFO *log.Logger
+)
+
+type Config struct {
+	Start       int     // workers for the first level
+	Step        int     // how many to add after a level passes
+	Max         int     // never go past this many
+	HoldSecs    int     // how long to measure each level, after it settles
+	MaxP99Ms    float64 // the SLO
+	MaxErrorPct float64
+}
+
+// what we saw at one number of workers
+type Level struct {
+	Workers  int
+	ReqSec   float64
+	P99Ms    float64 // the worst second's p99
+	ErrorPct float64
+}
+
+func (l Level) String() string {
+	return fmt.Sprintf("%d workers: %.0f req/s, p99 %.0fms, %.1f%% errors",
+		l.Workers, l.ReqSec, l.P99Ms, l.ErrorPct)
+}
+
+type Search struct {
+	cfg       Config
+	snapshots chan stats.Snapshot
+	targets   chan<- int
+	msgs      chan<- string
+
+	mu     sync.Mutex
+	result *Level // set once we're done
+}
+
+func MakeNew(cfg Config, targets chan<- int, msgs chan<- string, infoLog *log.Logger) *Search {
+	INFO = infoLog
+	if cfg.Step < 1 {
+		cfg.Step = 1
+	}
+	if cfg.HoldSecs < 1 {
+		cfg.HoldSecs = 1
+	}
+	return &Search{
+		cfg:       cfg,
+		snapshots: make(chan stats.Snapshot, 10),
+		targets:   targets,
+		msgs:      msgs,
+	}
+}
+
+// Report is called from the display loop, so it mustn't block. Run is
+// the one that might be waiting on a send.
+func (s *Search) Report(snap stats.Snapshot) {
+	select {
+	case s.snapshots <- snap:
+	default:
+		INFO.Println("capacity search is behind, dropping a snapshot")
+	}
+}
+
+// Result is the best level that met the SLO, once the search is over
+func (s *Search) Result() (level Level, done bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.result == nil {
+		return Level{}, false
+	}
+	return *s.result, true
+}
+
+func (s *Search) Run() {
+	st := &stepper{cfg: s.cfg, step: s.cfg.Step}
+	target := s.cfg.Start
+	s.msgs <- fmt.Sprintf("capacity search starting at %d workers", target)
+	s.targets <- target
+
+	var window []stats.Snapshot
+	settled := false
+	for snap := range s.snapshots {
+		if snap.Workers != target {
+			// still getting there
+			continue
+		}
+		if !settled {
+			// the first second at a new level is part ramp-up
+			settled = true
+			continue
+		}
+		window = append(window, snap)
+		if len(window) < s.cfg.HoldSecs {
+			continue
+		}
+
+		level := summarize(target, window)
+		window = window[:0]
+		settled = false
+		verdict := "ok"
+		if !st.passes(level) {
+			verdict = "over the SLO"
+		}
+		INFO.Println("capacity search: ", level, " ", verdict)
+		s.msgs <- fmt.Sprintf("%s %s", level, verdict)
+
+		next, done := st.next(level)
+		if done {
+			s.finish(st.best)
+			if st.best.Workers != target {
+				s.targets <- st.best.Workers
+			}
+			return
+		}
+		target = next
+		s.targets <- target
+	}
+}
+
+func (s *Search) finish(best Level) {
+	s.mu.Lock()
+	s.result = &best
+	s.mu.Unlock()
+	if best.Workers == 0 {
+		s.msgs <- "capacity search done: nothing met the SLO"
+	} else {
+		s.msgs <- fmt.Sprintf("capacity search done: %s", best)
+	}
+	INFO.Println("capacity search done, best was ", best)
+}
+
+// boil a level's worth of snapshots down into one Level. No traffic at
+// all counts as 100% errors, the server's not sustaining anything.
+func summarize(workers int, window []stats.Snapshot) Level {
+	l := Level{Workers: workers}
+	var reqs, fails, unanswered int64
+	for _, snap := range window {
+		reqs += snap.ReqSec
+		fails += snap.Fails
+		unanswered += snap.Unanswered
+		if snap.LatencyP99Ms > l.P99Ms {
+			l.P99Ms = snap.LatencyP99Ms
+		}
+	}
+	if len(window) > 0 {
+		l.ReqSec = float64(reqs) / float64(len(window))
+	}
+	if reqs+unanswered == 0 {
+		l.ErrorPct = 100
+	} else {
+		l.ErrorPct = stats.ErrorPct(reqs, fails, unanswered)
+	}
+	return l
+}
+
+// the deciding part of the search, without the timing
+type stepper struct {
+	cfg     Config
+	step    int
+	best    Level // the most workers that passed, Workers==0 if none
+	ceiling int   // the fewest workers that failed, 0 if none yet
+}
+
+func (st *stepper) passes(l Level) bool {
+	return l.P99Ms <= st.cfg.MaxP99Ms && l.ErrorPct <= st.cfg.MaxErrorPct
+}
+
+// where to go after measuring l
+func (st *stepper) next(l Level) (target int, done bool) {
+	if st.passes(l) {
+		if l.Workers > st.best.Workers {
+			st.best = l
+		}
+	} else if st.ceiling == 0 || l.Workers < st.ceiling {
+		st.ceiling = l.Workers
+		st.step /= 2
+	}
+
+	for st.step > 0 {
+		target = st.best.Workers + st.step
+		if st.ceiling > 0 && target >= st.ceiling {
+			// already know that's too many, try closer in
+			st.step /= 2
+			continue
+		}
+		if target > st.cfg.Max {
+			target = st.cfg.Max
+			if target <= st.best.Workers {
+				break
+			}
+		}
+		return target, false
+	}
+	return st.best.Workers, true
+}
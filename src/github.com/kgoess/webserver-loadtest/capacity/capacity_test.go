@@ -0,0 +1,126 @@
+package capacity
+
+import (
+	"io/ioutil"
+	"log"
+	"reflect"
+	"testing"
+	"time"
+
+	stats "github.com/kgoess/webserver-loadtest/stats"
+)
+
+var testConfig = Config{
+	Start:       10,
+	Step:        10,
+	Max:         100,
+	HoldSecs:    3,
+	MaxP99Ms:    200,
+	MaxErrorPct: 1,
+}
+
+// a server that's fine up to 37 workers and falls over after that
+func fakeLevel(workers int) Level {
+	if workers > 37 {
+		return Level{Workers: workers, ReqSec: 370, P99Ms: 900}
+	}
+	return Level{Workers: workers, ReqSec: float64(workers * 10), P99Ms: 50}
+}
+
+func TestStepper(t *testing.T) {
+	st := &stepper{cfg: testConfig, step: testConfig.Step}
+	var tried []int
+	target := testConfig.Start
+	for i := 0; i < 20; i++ {
+		tried = append(tried, target)
+		next, done := st.next(fakeLevel(target))
+		if done {
+			if next != 37 {
+				t.Errorf("search s/b settled on 37, got %d", next)
+			}
+			break
+		}
+		target = next
+	}
+	want := []int{10, 20, 30, 40, 35, 37, 39, 38}
+	if !reflect.DeepEqual(tried, want) {
+		t.Errorf("levels tried s/b %v, got %v", want, tried)
+	}
+}
+
+func TestStepperMax(t *testing.T) {
+	cfg := testConfig
+	cfg.Max = 25
+	st := &stepper{cfg: cfg, step: cfg.Step}
+	if next, done := st.next(fakeLevel(20)); done || next != 25 {
+		t.Errorf("after 20 s/b trying the max of 25, got %d (done %v)", next, done)
+	}
+	if next, done := st.next(fakeLevel(25)); !done || next != 25 {
+		t.Errorf("after passing at the max s/b done at 25, got %d (done %v)", next, done)
+	}
+}
+
+func TestStepperNothingPasses(t *testing.T) {
+	st := &stepper{cfg: testConfig, step: testConfig.Step}
+	target, done := testConfig.Start, false
+	for i := 0; i < 20 && !done; i++ {
+		target, done = st.next(Level{Workers: target, P99Ms: 5000})
+	}
+	if !done || target != 0 {
+		t.Errorf("s/b done with 0 workers, got %d (done %v)", target, done)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	l := summarize(5, []stats.Snapshot{
+		{ReqSec: 99, Fails: 1, Unanswered: 1, LatencyP99Ms: 20}, // a connection refused
+		{ReqSec: 100, Fails: 0, LatencyP99Ms: 40},
+	})
+	if l.Workers != 5 || l.ReqSec != 99.5 || l.P99Ms != 40 || l.ErrorPct != 0.5 {
+		t.Errorf("summary s/b 5 workers, 99.5 req/s, p99 40, 0.5%% errors, got %+v", l)
+	}
+	if l := summarize(5, []stats.Snapshot{{}}); l.ErrorPct != 100 {
+		t.Errorf("no traffic s/b 100%% errors, got %+v", l)
+	}
+	// every response a 500, they're in the requests and the fails both
+	l = summarize(5, []stats.Snapshot{
+		{ReqSec: 50, Fails: 50},
+		{ReqSec: 40, Fails: 40},
+	})
+	if l.ErrorPct != 100 {
+		t.Errorf("all 500s s/b 100%% errors, got %+v", l)
+	}
+	if st := (&stepper{cfg: testConfig}); st.passes(l) {
+		t.Errorf("all 500s shouldn't pass the SLO")
+	}
+}
+
+// the whole loop, with us playing the part of the collector
+func TestSearchRun(t *testing.T) {
+	targets := make(chan int)
+	msgs := make(chan string, 100)
+	s := MakeNew(testConfig, targets, msgs, log.New(ioutil.Discard, "", 0))
+	go s.Run()
+
+	var last int
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case target := <-targets:
+			last = target
+			l := fakeLevel(target)
+			// one to settle, then HoldSecs to measure
+			for i := 0; i <= testConfig.HoldSecs; i++ {
+				s.Report(stats.Snapshot{Workers: target, ReqSec: int64(l.ReqSec), LatencyP99Ms: l.P99Ms})
+			}
+		case <-timeout:
+			t.Fatalf("search didn't finish, last target was %d", last)
+		}
+		if result, done := s.Result(); done {
+			if result.Workers != 37 {
+				t.Errorf("result s/b 37 workers, got %v", result)
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,895 @@
+package main
+
+// The ncurses frontEnd lives here. Since ncurses wasn't designed with
+// concurrency in mind, only the main loop in realMain should be calling
+// any of this (windowRunloop just reads keys).
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	gc "code.google.com/p/goncurses"
+	eventlog "github.com/kgoess/webserver-loadtest/eventlog"
+	rb "github.com/kgoess/webserver-loadtest/ringbuffer"
+	stats "github.com/kgoess/webserver-loadtest/stats"
+)
+
+type colorsDefined struct {
+	whiteOnBlack int16
+	greenOnBlack int16
+	redOnBlack   int16
+}
+
+type resetScreenFn func()
+
+type termSize struct {
+	rows int
+	cols int // 0 for both means ncurses already knows, see watchForResize
+}
+
+// what the pan/zoom keys ask for, windowRunloop sends these to the main
+// loop since that's the only place allowed to draw
+type viewChange int
+
+const (
+	panBack viewChange = iota
+	panForward
+	panToStart
+	panToNow
+	zoomOut
+	zoomIn
+	nextChart
+	toggleHelp
+	toggleEvents
+	eventsUp
+	eventsDown
+	eventsPageUp
+	eventsPageDown
+	nextEventFilter
+)
+
+// seconds per column in the bars window
+var zoomLevels = []int{1, 10, 60}
+
+// what the bars window can show, 'v' goes round them
+var chartNames = []string{"req/s", "fails"}
+
+// what the event pane can show, 'f' goes round them while it's open
+var eventFilters = []string{"", eventlog.Error, eventlog.Control}
+
+// how many events we hang onto for scrolling back
+const eventLogSize = 500
+
+// the smallest screen drawDisplay can fit everything on
+const (
+	minRows = 23
+	minCols = 61
+)
+
+// cursesUI draws the windows from drawDisplay
+type cursesUI struct {
+	stdscr         *gc.Window
+	headerWin      *gc.Window // the keys get read here, it survives resizes
+	msgWin         *gc.Window
+	workerCountWin *gc.Window
+	durWin         *gc.Window
+	reqSecWin      *gc.Window
+	barsWin        *gc.Window
+	scaleWin       *gc.Window
+	maxWin         *gc.Window
+	latWin         *gc.Window // p50/p99 under the bars, same columns
+	latMaxWin      *gc.Window
+	helpWin        *gc.Window // on top of everything while showHelp
+	eventsWin      *gc.Window // over the charts while showEvents
+	colors         colorsDefined
+	resetScreen    resetScreenFn
+	tooSmall       bool
+	lastSnapshot   *stats.Snapshot // so we can redraw right away on a resize
+	zoom           int             // index into zoomLevels
+	pan            int             // how many seconds back from now the bars end, 0 is live
+	chart          int             // index into chartNames
+	showHelp       bool
+	events         *eventlog.Log
+	prompt         string // "how many workers?" while they're typing
+	showEvents     bool
+	eventsFilter   int // index into eventFilters
+	eventsScroll   int // how many lines up from the newest
+}
+
+func startCursesUI(
+	infoMsgsCh chan<- ncursesMsg,
+	exitCh chan<- int,
+	changeNumRequestersCh chan<- interface{},
+	resetStatsCh chan<- bool,
+	resizeCh chan<- termSize,
+	viewCh chan<- viewChange,
+) *cursesUI {
+	stdscr, colors, resetScreen := initializeNcurses()
+	ui := &cursesUI{
+		stdscr:      stdscr,
+		colors:      *colors,
+		resetScreen: resetScreen,
+		events:      eventlog.MakeNew(eventLogSize),
+	}
+
+	// enable the use of the keypad on the header so the arrow keys are
+	// available
+	_, cols := stdscr.MaxYX()
+	ui.headerWin = createWindow(1, cols, 0, 0)
+	ui.headerWin.Keypad(true)
+
+	ui.draw()
+
+	go windowRunloop(infoMsgsCh, exitCh, changeNumRequestersCh, resetStatsCh, resizeCh, viewCh, ui.headerWin)
+	go watchForResize(resizeCh)
+	return ui
+}
+
+// draw the stuff on the screen, sized to fit
+func (ui *cursesUI) draw() {
+	rows, cols := ui.stdscr.MaxYX()
+	ui.headerWin.Erase()
+	if rows < minRows || cols < minCols {
+		ui.tooSmall = true
+		ui.headerWin.MovePrint(0, 0, fmt.Sprintf("Terminal too small, need %dx%d. Press 'q' to exit", minCols, minRows))
+		ui.headerWin.NoutRefresh()
+		gc.Update()
+		return
+	}
+	ui.tooSmall = false
+
+	ui.drawHeader()
+	ui.msgWin, ui.workerCountWin, ui.durWin, ui.reqSecWin, ui.barsWin, ui.scaleWin, ui.maxWin, ui.latWin, ui.latMaxWin = drawDisplay(ui.stdscr, rows, cols)
+	ui.updateMsgWin()
+	if ui.showEvents {
+		ui.eventsWin = createWindow(rows-1, cols, 1, 0)
+		ui.updateEventsWin()
+	}
+	if ui.showHelp {
+		ui.helpWin = drawHelp(rows, cols)
+	}
+}
+
+// the windows underneath get refreshed every second, so these have to
+// go back on top after them
+func (ui *cursesUI) raiseOverlays() {
+	for _, win := range []*gc.Window{ui.eventsWin, ui.helpWin} {
+		if win != nil {
+			win.Touch()
+			win.NoutRefresh()
+		}
+	}
+}
+
+// the startup message, plus what the bars are showing
+func (ui *cursesUI) drawHeader() {
+	_, cols := ui.headerWin.MaxYX()
+	view := fmt.Sprintf("[%s %ds/col, live]", chartNames[ui.chart], zoomLevels[ui.zoom])
+	if ui.pan > 0 {
+		view = fmt.Sprintf("[%s %ds/col, %v ago]", chartNames[ui.chart], zoomLevels[ui.zoom], time.Duration(ui.pan)*time.Second)
+	}
+	ui.headerWin.Erase()
+	help := "'q' exits, '?' for help"
+	if ui.lastSnapshot != nil {
+		help = fmt.Sprintf("'?' for help, %d in flight", ui.lastSnapshot.InFlight)
+		if faults := ui.lastSnapshot.TotalFaults(); faults > 0 {
+			help += fmt.Sprintf(", %d faults", faults)
+		}
+	}
+	ui.headerWin.MovePrint(0, 0, help)
+	ui.headerWin.MovePrint(0, cols-len(view)-1, view)
+	ui.headerWin.NoutRefresh()
+}
+
+func (ui *cursesUI) deleteWindows() {
+	for _, win := range []*gc.Window{ui.msgWin, ui.workerCountWin, ui.durWin, ui.reqSecWin, ui.barsWin, ui.scaleWin, ui.maxWin, ui.latWin, ui.latMaxWin, ui.helpWin, ui.eventsWin} {
+		if win != nil {
+			win.Delete()
+		}
+	}
+	ui.msgWin, ui.workerCountWin, ui.durWin, ui.reqSecWin, ui.barsWin, ui.scaleWin, ui.maxWin, ui.latWin, ui.latMaxWin, ui.helpWin, ui.eventsWin = nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil
+}
+
+func (ui *cursesUI) Resize(size termSize) {
+	if size.rows > 0 && size.cols > 0 {
+		gc.ResizeTerm(size.rows, size.cols)
+	}
+	INFO.Printf("resizing display to %dx%d", size.cols, size.rows)
+	ui.redraw()
+}
+
+// start over from a blank screen
+func (ui *cursesUI) redraw() {
+	ui.deleteWindows()
+	ui.stdscr.Clear()
+	ui.stdscr.NoutRefresh()
+	_, cols := ui.stdscr.MaxYX()
+	ui.headerWin.Resize(1, cols)
+	ui.draw()
+	if ui.lastSnapshot != nil {
+		ui.Report(*ui.lastSnapshot)
+	}
+}
+
+func (ui *cursesUI) ChangeView(v viewChange) {
+	switch v {
+	case toggleHelp:
+		ui.showHelp = !ui.showHelp
+		ui.redraw()
+		return
+	case toggleEvents:
+		ui.showEvents = !ui.showEvents
+		ui.eventsScroll = 0
+		ui.redraw()
+		return
+	case eventsUp, eventsDown, eventsPageUp, eventsPageDown, nextEventFilter:
+		ui.scrollEvents(v)
+		return
+	}
+	if ui.tooSmall {
+		return
+	}
+	// a quarter of the screen at a time
+	_, barsWidth := ui.barsWin.MaxYX()
+	step := (barsWidth - 2) / 4 * zoomLevels[ui.zoom]
+
+	switch v {
+	case panBack:
+		ui.pan += step
+	case panForward:
+		ui.pan -= step
+	case panToStart:
+		ui.pan = math.MaxInt32 // Report will pull it back in
+	case panToNow:
+		ui.pan = 0
+	case zoomOut:
+		if ui.zoom < len(zoomLevels)-1 {
+			ui.zoom++
+		}
+	case zoomIn:
+		if ui.zoom > 0 {
+			ui.zoom--
+		}
+	case nextChart:
+		ui.chart = (ui.chart + 1) % len(chartNames)
+	}
+	if ui.pan < 0 {
+		ui.pan = 0
+	}
+	if ui.lastSnapshot != nil {
+		ui.Report(*ui.lastSnapshot)
+	}
+}
+
+func (ui *cursesUI) scrollEvents(v viewChange) {
+	if ui.eventsWin == nil {
+		return
+	}
+	height, _ := ui.eventsWin.MaxYX()
+	page := height - 3
+	switch v {
+	case eventsUp:
+		ui.eventsScroll++
+	case eventsDown:
+		ui.eventsScroll--
+	case eventsPageUp:
+		ui.eventsScroll += page
+	case eventsPageDown:
+		ui.eventsScroll -= page
+	case nextEventFilter:
+		ui.eventsFilter = (ui.eventsFilter + 1) % len(eventFilters)
+		ui.eventsScroll = 0
+	}
+	ui.updateEventsWin()
+	ui.raiseOverlays()
+}
+
+func (ui *cursesUI) ShowMsg(msg ncursesMsg) {
+	switch msg.msgType {
+	case MSG_TYPE_PROMPT:
+		ui.prompt = msg.msgStr
+	case MSG_TYPE_RESULT:
+		ui.events.AddWithID(eventlog.Error, msg.msgStr, msg.hitId)
+	default:
+		ui.prompt = ""
+		ui.events.Add(eventlog.Control, msg.msgStr)
+	}
+	if ui.tooSmall {
+		return
+	}
+	ui.updateMsgWin()
+	if msg.currentCount >= 0 {
+		ui.workerCountWin.MovePrint(1, 1, fmt.Sprintf("%5d", msg.currentCount))
+		ui.workerCountWin.NoutRefresh()
+	}
+	if ui.eventsWin != nil {
+		ui.updateEventsWin()
+	}
+	ui.raiseOverlays()
+}
+
+func (ui *cursesUI) Update() {
+	gc.Update()
+}
+
+func (ui *cursesUI) Close() {
+	ui.deleteWindows()
+	ui.headerWin.Delete()
+	ui.resetScreen()
+}
+
+func (ui *cursesUI) Report(s stats.Snapshot) {
+	// if we're scrolled back, stay looking at the same seconds while
+	// new ones come in
+	if ui.pan > 0 && ui.lastSnapshot != nil && s.Time.After(ui.lastSnapshot.Time) {
+		ui.pan += int(s.Time.Sub(ui.lastSnapshot.Time).Seconds() + 0.5)
+	}
+	ui.lastSnapshot = &s
+	if ui.tooSmall {
+		return
+	}
+
+	// that %7s should really be determined from durWidth
+	if s.LatencyCount > 0 {
+		ui.durWin.MovePrint(1, 1, fmt.Sprintf("%11.2f\n (avg last %d)", s.LatencyMs, s.LatencyLookback))
+	} else {
+		ui.durWin.MovePrint(1, 1, fmt.Sprintf("%11s", "0"))
+	}
+	ui.durWin.NoutRefresh()
+
+	ui.reqSecWin.MovePrint(1, 1, fmt.Sprintf("%14s",
+		fmt.Sprintf("%d/%2.2d/%2.2d", s.ReqSec, s.ReqSecAvg5, s.ReqSecAvg60)))
+	ui.reqSecWin.NoutRefresh()
+
+	ui.workerCountWin.MovePrint(1, 1, fmt.Sprintf("%5d", s.Workers))
+	ui.workerCountWin.NoutRefresh()
+
+	barsHeight, barsWidth := ui.barsWin.MaxYX()
+	perCol := zoomLevels[ui.zoom]
+	secsShown := (barsWidth - 2) * perCol
+	if maxPan := s.ReqHistory.Len() - secsShown; ui.pan > maxPan {
+		ui.pan = maxPan
+	}
+	if ui.pan < 0 {
+		ui.pan = 0
+	}
+	live := ui.pan == 0
+
+	var secs, failSecs, p50Secs, p99Secs []int64
+	if live {
+		// the completed seconds, plus the one that's still in progress on
+		// the far right, which has no latency yet
+		secs = append(s.ReqHistory.Last(secsShown-1), s.Bars[s.Time.Second()])
+		failSecs = append(s.FailHistory.Last(secsShown-1), s.FailBars[s.Time.Second()])
+		p50Secs = append(s.P50History.Last(secsShown-1), 0)
+		p99Secs = append(s.P99History.Last(secsShown-1), 0)
+	} else {
+		secs = s.ReqHistory.Range(ui.pan, secsShown)
+		failSecs = s.FailHistory.Range(ui.pan, secsShown)
+		p50Secs = s.P50History.Range(ui.pan, secsShown)
+		p99Secs = s.P99History.Range(ui.pan, secsShown)
+	}
+	cols := rb.Aggregate(secs, perCol)
+	failCols := rb.Aggregate(failSecs, perCol)
+	if chartNames[ui.chart] == "fails" {
+		// all red, on their own scale
+		cols = failCols
+	}
+	p50Cols := rb.AggregateMax(p50Secs, perCol)
+	p99Cols := rb.AggregateMax(p99Secs, perCol)
+
+	max := int64(0)
+	for _, val := range cols {
+		if val > max {
+			max = val
+		}
+	}
+	currentScale := stats.Scale(max, barsHeight-2)
+
+	ui.maxWin.MovePrint(1, 1, fmt.Sprintf("%5d", max))
+	ui.maxWin.NoutRefresh()
+	ui.scaleWin.MovePrint(1, 1, fmt.Sprintf("%5d", currentScale))
+	ui.scaleWin.NoutRefresh()
+	updateBarsWin(cols, failCols, ui.barsWin, ui.colors, currentScale, live)
+
+	maxLat := int64(0)
+	for _, val := range p99Cols {
+		if val > maxLat {
+			maxLat = val
+		}
+	}
+	ui.latMaxWin.MovePrint(1, 1, fmt.Sprintf("%5d", maxLat))
+	ui.latMaxWin.NoutRefresh()
+	updateLatencyWin(p50Cols, p99Cols, ui.latWin, ui.colors, maxLat)
+	ui.drawHeader()
+	ui.raiseOverlays()
+}
+
+func initializeNcurses() (stdscr *gc.Window, colors *colorsDefined, resetScreen resetScreenFn) {
+
+	stdscr, err := gc.Init()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer gc.End()
+	resetScreen = func() {
+		gc.End()
+	}
+
+	// Turn off character echo, hide the cursor and disable input buffering
+	gc.Echo(false)
+	gc.CBreak(true)
+	gc.StartColor()
+
+	// initialize colors
+	whiteOnBlack := int16(1)
+	gc.InitPair(whiteOnBlack, gc.C_WHITE, gc.C_BLACK)
+	greenOnBlack := int16(2)
+	gc.InitPair(greenOnBlack, gc.C_GREEN, gc.C_BLACK)
+	redOnBlack := int16(3)
+	gc.InitPair(redOnBlack, gc.C_RED, gc.C_BLACK)
+
+	// Set the cursor visibility.
+	// Options are: 0 (invisible/hidden), 1 (normal) and 2 (extra-visible)
+	gc.Cursor(0)
+
+	colors = &colorsDefined{whiteOnBlack, greenOnBlack, redOnBlack}
+
+	return
+}
+
+func drawDisplay(
+	stdscr *gc.Window,
+	rows int,
+	cols int,
+) (
+	msgWin *gc.Window,
+	workerCountWin *gc.Window,
+	durWin *gc.Window,
+	reqSecWin *gc.Window,
+	barsWin *gc.Window,
+	scaleWin *gc.Window,
+	maxWin *gc.Window,
+	latWin *gc.Window,
+	latMaxWin *gc.Window,
+) {
+
+	// Create message window, it gets whatever width the little windows
+	// to the right of it don't need
+	msgHeight, msgWidth := 5, cols-41
+	msgY, msgX := 1, 0
+	msgWin = createWindow(msgHeight, msgWidth, msgY, msgX)
+	msgWin.Box(0, 0)
+	msgWin.NoutRefresh()
+
+	// Create the counter window, showing how many goroutines are active
+	ctrHeight, ctrWidth := 3, 7
+	ctrY := 2
+	ctrX := msgWidth + 1
+	stdscr.MovePrint(1, ctrX+1, "thrds")
+	stdscr.NoutRefresh()
+	workerCountWin = createWindow(ctrHeight, ctrWidth, ctrY, ctrX)
+	workerCountWin.Box(0, 0)
+	workerCountWin.NoutRefresh()
+
+	// Create the avg duration window, showing 5 second moving average
+	durHeight, durWidth := 4, 14
+	durY := 2
+	durX := ctrX + ctrWidth + 1
+	stdscr.MovePrint(1, durX+1, "duration ms")
+	stdscr.NoutRefresh()
+	durWin = createWindow(durHeight, durWidth, durY, durX)
+	durWin.Box(0, 0)
+	durWin.NoutRefresh()
+
+	// Create the requests/sec window,
+	reqSecHeight, reqSecWidth := 3, 16
+	reqSecY := 2
+	reqSecX := durX + durWidth + 1
+	stdscr.MovePrint(1, reqSecX+1, "req/s 1/5/60")
+	stdscr.NoutRefresh()
+	reqSecWin = createWindow(reqSecHeight, reqSecWidth, reqSecY, reqSecX)
+	reqSecWin.Box(0, 0)
+	reqSecWin.NoutRefresh()
+
+	// Create the bars window, showing the moving display of bars, it
+	// gets the rest of the screen bar the latency window
+	latHeight := 7
+	barsY := msgHeight + 1
+	barsX := 9 // leave space for scale window
+	barsWidth := cols - barsX
+	barsHeight := rows - barsY - latHeight
+	barsWin = createWindow(barsHeight, barsWidth, barsY, barsX)
+	barsWin.Box(0, 0)
+	barsWin.NoutRefresh()
+
+	// Max window, showing the max of the bars on screen
+	maxWidth := 7
+	maxHeight := 3
+	maxY := barsY + barsHeight - 8
+	maxX := 1
+	stdscr.MovePrint(maxY, 1, "max:")
+	stdscr.NoutRefresh()
+	maxY += 1
+	maxWin = createWindow(maxHeight, maxWidth, maxY, maxX)
+	maxWin.Box(0, 0)
+	maxWin.NoutRefresh()
+
+	// Scale window, showing our current scaling factor for the bars display
+	scaleWidth := 7
+	scaleHeight := 3
+	scaleY := barsY + barsHeight - 4
+	scaleX := 1
+	stdscr.MovePrint(scaleY, 1, "scale:")
+	stdscr.NoutRefresh()
+	scaleY += 1
+	scaleWin = createWindow(scaleHeight, scaleWidth, scaleY, scaleX)
+	scaleWin.Box(0, 0)
+	scaleWin.MovePrint(1, 1, fmt.Sprintf("%5s", "1"))
+	scaleWin.NoutRefresh()
+
+	// Create the latency window under the bars, lined up with them so
+	// you can see where the req/s flatten out and the latency takes off
+	latY := barsY + barsHeight
+	latWin = createWindow(latHeight, barsWidth, latY, barsX)
+	latWin.Box(0, 0)
+	latWin.NoutRefresh()
+
+	// and the highest p99 on screen, which is the top of the chart
+	latMaxY := latY
+	stdscr.MovePrint(latMaxY, 1, "p99 ms")
+	stdscr.NoutRefresh()
+	latMaxY += 1
+	latMaxWin = createWindow(3, 7, latMaxY, 1)
+	latMaxWin.Box(0, 0)
+	latMaxWin.NoutRefresh()
+
+	// Update will flush only the characters which have changed between the
+	// physical screen and the virtual screen, minimizing the number of
+	// characters which must be sent
+	gc.Update()
+
+	return
+}
+
+// the key bindings, in a box in the middle of the screen
+func drawHelp(rows int, cols int) *gc.Window {
+	lines := strings.Split(helpText+"\n\npress any key", "\n")
+	width := 0
+	for _, line := range lines {
+		if len(line) > width {
+			width = len(line)
+		}
+	}
+	height, width := len(lines)+2, width+4
+	if height > rows || width > cols {
+		return nil
+	}
+	helpWin := createWindow(height, width, (rows-height)/2, (cols-width)/2)
+	helpWin.Erase()
+	helpWin.Box(0, 0)
+	for i, line := range lines {
+		helpWin.MovePrint(i+1, 2, line)
+	}
+	helpWin.NoutRefresh()
+	return helpWin
+}
+
+func createWindow(height int, width int, y int, x int) (win *gc.Window) {
+	win, err := gc.NewWindow(height, width, y, x)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return
+}
+
+// the newest few events, or what they're typing
+func (ui *cursesUI) updateMsgWin() {
+	msgHeight, msgWidth := ui.msgWin.MaxYX()
+	lines := msgHeight - 2
+	entries := ui.events.Entries("")
+	if len(entries) > lines {
+		entries = entries[len(entries)-lines:]
+	}
+	ui.msgWin.Erase()
+	ui.msgWin.Box(0, 0)
+	for i, e := range entries {
+		ui.msgWin.MovePrint(i+1, 1, fitWidth(e.String(), msgWidth-2))
+	}
+	if ui.prompt != "" {
+		ui.msgWin.MovePrint(lines, 1, fmt.Sprintf("%-*s", msgWidth-2, fitWidth(ui.prompt, msgWidth-2)))
+	}
+	ui.msgWin.NoutRefresh()
+}
+
+// the whole event log, newest at the bottom, over the top of the charts
+func (ui *cursesUI) updateEventsWin() {
+	height, width := ui.eventsWin.MaxYX()
+	lines := height - 2
+	entries := ui.events.Entries(eventFilters[ui.eventsFilter])
+
+	if ui.eventsScroll > len(entries)-lines {
+		ui.eventsScroll = len(entries) - lines
+	}
+	if ui.eventsScroll < 0 {
+		ui.eventsScroll = 0
+	}
+	end := len(entries) - ui.eventsScroll
+	start := end - lines
+	if start < 0 {
+		start = 0
+	}
+
+	filterName := eventFilters[ui.eventsFilter]
+	if filterName == "" {
+		filterName = "all"
+	}
+	ui.eventsWin.Erase()
+	ui.eventsWin.Box(0, 0)
+	ui.eventsWin.MovePrint(0, 2, fitWidth(fmt.Sprintf(" events: %s, %d of %d  (up/down scroll, f filter, e close) ",
+		filterName, end, len(entries)), width-4))
+	for i, e := range entries[start:end] {
+		line := e.String()
+		if e.LastID != "" {
+			line += "  last " + e.LastID
+		}
+		if e.Kind == eventlog.Error {
+			ui.eventsWin.ColorOff(ui.colors.whiteOnBlack)
+			ui.eventsWin.ColorOn(ui.colors.redOnBlack)
+			ui.eventsWin.MovePrint(i+1, 1, fitWidth(line, width-2))
+			ui.eventsWin.ColorOff(ui.colors.redOnBlack)
+			ui.eventsWin.ColorOn(ui.colors.whiteOnBlack)
+		} else {
+			ui.eventsWin.MovePrint(i+1, 1, fitWidth(line, width-2))
+		}
+	}
+	ui.eventsWin.NoutRefresh()
+}
+
+func fitWidth(s string, width int) string {
+	if width < 0 {
+		width = 0
+	}
+	if len(s) > width {
+		return s[:width]
+	}
+	return s
+}
+
+func updateBarsWin(cols []int64, failCols []int64, barsWin *gc.Window, colors colorsDefined, scale int64, live bool) {
+
+	whiteOnBlack := colors.whiteOnBlack
+	redOnBlack := colors.redOnBlack
+	greenOnBlack := colors.greenOnBlack
+	barsWin.Erase()
+	barsWin.Box(0, 0)
+	edibleCopy := make([]int64, len(cols))
+	copy(edibleCopy, cols)
+	barsHeight, barsWidth := barsWin.MaxYX()
+	// newest on the right, until we've got enough history to fill the
+	// window it starts partway across
+	offset := barsWidth - 2 - len(edibleCopy)
+	if offset < 0 {
+		edibleCopy = edibleCopy[-offset:]
+		failCols = failCols[-offset:]
+		offset = 0
+	}
+	for row := 0; row < barsHeight-2; row++ {
+		for col := range edibleCopy {
+			if edibleCopy[col]/scale > 0 {
+				turnOffColor := int16(0)
+				currChar := "="
+				// row is an int--32-bit, right?
+				if shouldShowFail(failCols[col], scale, row) {
+					barsWin.ColorOff(whiteOnBlack)
+					barsWin.ColorOn(redOnBlack)
+					currChar = "x"
+					turnOffColor = redOnBlack
+
+				} else if live && col >= len(edibleCopy)-2 {
+					// current second is still in progress, so make the previous second
+					// green too--not precisely correct, but close enough here
+					barsWin.ColorOff(whiteOnBlack)
+					barsWin.ColorOn(greenOnBlack)
+					turnOffColor = greenOnBlack
+				}
+
+				barsWin.MovePrint(barsHeight-2-row, offset+col+1, currChar)
+
+				if turnOffColor != 0 {
+					barsWin.ColorOff(turnOffColor)
+					barsWin.ColorOn(whiteOnBlack)
+				}
+
+				edibleCopy[col] = edibleCopy[col] - scale
+			}
+		}
+	}
+	barsWin.NoutRefresh()
+}
+
+// one dot per column for p50 and one for p99, scaled so max is the top row
+func updateLatencyWin(p50Cols []int64, p99Cols []int64, latWin *gc.Window, colors colorsDefined, max int64) {
+	latWin.Erase()
+	latWin.Box(0, 0)
+	latWin.MovePrint(0, 2, " p50 . p99 * ")
+	latHeight, latWidth := latWin.MaxYX()
+	rows := int64(latHeight - 2)
+	offset := latWidth - 2 - len(p99Cols)
+	if offset < 0 {
+		p50Cols = p50Cols[-offset:]
+		p99Cols = p99Cols[-offset:]
+		offset = 0
+	}
+	if max == 0 {
+		latWin.NoutRefresh()
+		return
+	}
+	// which row from the bottom a value lands on
+	rowFor := func(val int64) int {
+		return int((val*(rows-1) + max/2) / max)
+	}
+	for col := range p99Cols {
+		x := offset + col + 1
+		if p50Cols[col] > 0 {
+			latWin.MovePrint(latHeight-2-rowFor(p50Cols[col]), x, ".")
+		}
+		if p99Cols[col] > 0 {
+			latWin.ColorOff(colors.whiteOnBlack)
+			latWin.ColorOn(colors.redOnBlack)
+			latWin.MovePrint(latHeight-2-rowFor(p99Cols[col]), x, "*")
+			latWin.ColorOff(colors.redOnBlack)
+			latWin.ColorOn(colors.whiteOnBlack)
+		}
+	}
+	latWin.NoutRefresh()
+}
+
+// Called from updateBarsWin
+// The scale factor would result in a fractional value if there's
+// only one fail this second--we always want to show a fail marker
+// if there are *any* fails, otherwise they become invisible
+func shouldShowFail(numFailsThisSec int64, scale int64, rowNum int) bool {
+	var rc bool
+	if numFailsThisSec/scale > int64(rowNum) ||
+		rowNum == 0 && numFailsThisSec > 0 {
+		rc = true
+	} else {
+		rc = false
+	}
+	return rc
+}
+
+func windowRunloop(
+	infoMsgsCh chan<- ncursesMsg,
+	exitCh chan<- int,
+	changeNumRequestersCh chan<- interface{},
+	resetStatsCh chan<- bool,
+	resizeCh chan<- termSize,
+	viewCh chan<- viewChange,
+	win *gc.Window,
+) {
+	var entry targetEntry
+	helpShown := false
+	eventsShown := false
+	for {
+		key := win.GetChar()
+		if key == gc.KEY_RESIZE {
+			resizeCh <- termSize{}
+			continue
+		}
+		if helpShown {
+			// any key gets rid of it
+			helpShown = false
+			viewCh <- toggleHelp
+			continue
+		}
+		if eventsShown {
+			switch key {
+			case 'q':
+				exitCh <- 0
+			case 'e', 27:
+				eventsShown = false
+				viewCh <- toggleEvents
+			case 'k', gc.KEY_UP:
+				viewCh <- eventsUp
+			case 'j', gc.KEY_DOWN:
+				viewCh <- eventsDown
+			case gc.KEY_PAGEUP:
+				viewCh <- eventsPageUp
+			case gc.KEY_PAGEDOWN:
+				viewCh <- eventsPageDown
+			case 'f':
+				viewCh <- nextEventFilter
+			}
+			continue
+		}
+		if entry.active {
+			switch key {
+			case gc.KEY_ENTER:
+				key = '\n'
+			case gc.KEY_BACKSPACE:
+				key = 127
+			}
+			if target, done := entry.key(int(key), infoMsgsCh); done {
+				changeThreadsTo(infoMsgsCh, changeNumRequestersCh, target)
+			}
+			continue
+		}
+		switch key {
+		case 'q':
+			exitCh <- 0
+		case 's', '+', '=', gc.KEY_UP:
+			increaseThreads(infoMsgsCh, changeNumRequestersCh)
+		case '-', gc.KEY_DOWN:
+			decreaseThreads(infoMsgsCh, changeNumRequestersCh)
+		case ']', gc.KEY_PAGEUP:
+			changeThreadsBy(infoMsgsCh, changeNumRequestersCh, 10)
+		case '[', gc.KEY_PAGEDOWN:
+			changeThreadsBy(infoMsgsCh, changeNumRequestersCh, -10)
+		case 't':
+			entry.start(infoMsgsCh)
+		case 'p':
+			togglePause(infoMsgsCh)
+		case 'r':
+			resetStats(infoMsgsCh, resetStatsCh)
+		case 'f':
+			toggleFaults(infoMsgsCh)
+		case 'v':
+			viewCh <- nextChart
+		case '?':
+			helpShown = true
+			viewCh <- toggleHelp
+		case 'e':
+			eventsShown = true
+			viewCh <- toggleEvents
+		case gc.KEY_LEFT:
+			viewCh <- panBack
+		case gc.KEY_RIGHT:
+			viewCh <- panForward
+		case gc.KEY_HOME:
+			viewCh <- panToStart
+		case gc.KEY_END:
+			viewCh <- panToNow
+		case 'z':
+			viewCh <- zoomOut
+		case 'Z':
+			viewCh <- zoomIn
+		}
+	}
+}
+
+// ncurses turns SIGWINCH into KEY_RESIZE by itself, but only if its signal
+// handler gets it and not the Go runtime's, so we listen too and ask the
+// tty for the new size ourselves
+func watchForResize(resizeCh chan<- termSize) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGWINCH)
+	for range sigCh {
+		rows, cols, err := getTermSize()
+		if err != nil {
+			INFO.Println("couldn't get the terminal size: ", err)
+			continue
+		}
+		resizeCh <- termSize{rows, cols}
+	}
+}
+
+func getTermSize() (rows int, cols int, err error) {
+	var ws struct {
+		Row, Col, Xpixel, Ypixel uint16
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, os.Stdout.Fd(),
+		uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&ws)))
+	if errno != 0 {
+		return 0, 0, errno
+	}
+	return int(ws.Row), int(ws.Col), nil
+}
@@ -0,0 +1,103 @@
+package eventlog
+
+// The scrollback for the curses event pane. When the server starts
+// refusing connections every requester hits the same error hundreds of
+// times a second, so repeats get folded into one entry with a count
+// instead of pushing everything else off the top.
+//
+// Not safe for concurrent use, the display's main loop owns it.
+
+import (
+	"fmt"
+	"time"
+)
+
+// the kinds of events
+const (
+	Error   = "error"   // failed requests
+	Control = "control" // workers changing, pauses, resets...
+)
+
+type Entry struct {
+	Kind  string
+	Text  string
+	Count int
+	First time.Time
+	Last  time.Time
+
+	LastID string // the correlation id of the newest one, if it had one
+}
+
+func (e Entry) String() string {
+	if e.Count > 1 {
+		return fmt.Sprintf("%s %s (x%d)", e.Last.Format("15:04:05"), e.Text, e.Count)
+	}
+	return fmt.Sprintf("%s %s", e.Last.Format("15:04:05"), e.Text)
+}
+
+type Log struct {
+	entries  []*Entry // oldest first, by when they last happened
+	byKey    map[string]*Entry
+	capacity int
+}
+
+func MakeNew(capacity int) *Log {
+	return &Log{
+		byKey:    make(map[string]*Entry),
+		capacity: capacity,
+	}
+}
+
+func (l *Log) Add(kind string, text string) {
+	l.AddAt(time.Now(), kind, text)
+}
+
+// AddWithID is Add for a failed request, the id isn't part of what gets
+// folded together, the entry just keeps the latest one
+func (l *Log) AddWithID(kind string, text string, id string) {
+	l.AddAt(time.Now(), kind, text)
+	l.entries[len(l.entries)-1].LastID = id
+}
+
+// a repeat of something already in the log bumps its count and moves it
+// down to the newest end
+func (l *Log) AddAt(t time.Time, kind string, text string) {
+	key := kind + "\x00" + text
+	if e, ok := l.byKey[key]; ok {
+		e.Count++
+		e.Last = t
+		for i := range l.entries {
+			if l.entries[i] == e {
+				l.entries = append(l.entries[:i], l.entries[i+1:]...)
+				break
+			}
+		}
+		l.entries = append(l.entries, e)
+		return
+	}
+
+	e := &Entry{Kind: kind, Text: text, Count: 1, First: t, Last: t}
+	l.entries = append(l.entries, e)
+	l.byKey[key] = e
+	if len(l.entries) > l.capacity {
+		oldest := l.entries[0]
+		delete(l.byKey, oldest.Kind+"\x00"+oldest.Text)
+		l.entries = l.entries[1:]
+	}
+}
+
+// Entries returns copies of the entries of that kind, or all of them for
+// "", oldest first
+func (l *Log) Entries(kind string) []Entry {
+	entries := make([]Entry, 0, len(l.entries))
+	for _, e := range l.entries {
+		if kind == "" || e.Kind == kind {
+			entries = append(entries, *e)
+		}
+	}
+	return entries
+}
+
+func (l *Log) Len() int {
+	return len(l.entries)
+}
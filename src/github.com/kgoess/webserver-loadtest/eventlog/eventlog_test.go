@@ -0,0 +1,76 @@
+package eventlog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDedup(t *testing.T) {
+	l := MakeNew(10)
+	t0 := time.Date(2014, 10, 22, 13, 14, 15, 0, time.Local)
+
+	l.AddAt(t0, Error, "connection refused")
+	l.AddAt(t0.Add(time.Second), Control, "increasing threads")
+	l.AddAt(t0.Add(2*time.Second), Error, "connection refused")
+	l.AddAt(t0.Add(3*time.Second), Error, "connection refused")
+
+	entries := l.Entries("")
+	if len(entries) != 2 {
+		t.Fatalf("s/b 2 entries after dedup, got %d: %v", len(entries), entries)
+	}
+	// the repeated one moves to the end
+	refused := entries[1]
+	if refused.Text != "connection refused" || refused.Count != 3 {
+		t.Errorf("newest entry s/b connection refused x3, got %+v", refused)
+	}
+	if !refused.First.Equal(t0) || !refused.Last.Equal(t0.Add(3*time.Second)) {
+		t.Errorf("first/last s/b the first and third times, got %v/%v", refused.First, refused.Last)
+	}
+	if s := refused.String(); s != "13:14:18 connection refused (x3)" {
+		t.Errorf("String() s/b the last time and the count, got %q", s)
+	}
+	if s := entries[0].String(); s != "13:14:16 increasing threads" {
+		t.Errorf("String() with no repeats s/b no count, got %q", s)
+	}
+}
+
+func TestFilterAndCapacity(t *testing.T) {
+	l := MakeNew(3)
+	l.Add(Control, "one")
+	l.Add(Error, "two")
+	l.Add(Control, "three")
+	l.Add(Error, "four")
+
+	if l.Len() != 3 {
+		t.Errorf("Len() s/b capped at 3, got %d", l.Len())
+	}
+	errors := l.Entries(Error)
+	if len(errors) != 2 || errors[0].Text != "two" || errors[1].Text != "four" {
+		t.Errorf("errors s/b two, four, got %v", errors)
+	}
+
+	// "one" fell off the end, so it starts over at a count of one
+	l.Add(Control, "one")
+	controls := l.Entries(Control)
+	if len(controls) != 2 || controls[1].Text != "one" || controls[1].Count != 1 {
+		t.Errorf("controls s/b three, one(x1), got %v", controls)
+	}
+}
+
+func TestLastID(t *testing.T) {
+	l := MakeNew(10)
+	l.AddWithID(Error, "GET /: 500", "run-web1-3-17")
+	l.Add(Control, "increasing threads")
+	l.AddWithID(Error, "GET /: 500", "run-web1-4-2")
+
+	entries := l.Entries(Error)
+	if len(entries) != 1 || entries[0].Count != 2 {
+		t.Fatalf("ids s/b left out of the folding, got %v", entries)
+	}
+	if entries[0].LastID != "run-web1-4-2" {
+		t.Errorf("LastID s/b the newest one, got %q", entries[0].LastID)
+	}
+	if s := entries[0].String(); s != entries[0].Last.Format("15:04:05")+" GET /: 500 (x2)" {
+		t.Errorf("String() s/b without the id, got %q", s)
+	}
+}
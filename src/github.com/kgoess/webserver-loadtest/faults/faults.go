@@ -0,0 +1,352 @@
+package faults
+
+// Client-side fault injection, for seeing how the server copes with
+// clients that misbehave. Each kind happens to its own percentage of the
+// requests (--fault abort:5 --fault bad-header:1):
+//
+//	bad-path       the old --random-fails, the url's path gets mangled so
+//	               the server should 404
+//	abort          hang up as soon as the response starts coming back
+//	close-early    send the request and close the connection without
+//	               waiting for any of the response
+//	truncate-body  promise a body in the Content-Length and then only
+//	               send half of it before closing
+//	delay          stall for a while halfway through the headers, like a
+//	               slow (or slowloris) client
+//	bad-header     send a header line that isn't valid HTTP
+//
+// Everything but bad-path goes around the http client over a connection
+// of its own, and isn't counted in the normal request stats, just in the
+// counts here, so the latency numbers stay about the real requests.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// debugging kludge--is this really the way to share global loggers?
+var (
+	INFO *log.Logger
+)
+
+const (
+	BadPath      = "bad-path"
+	Abort        = "abort"
+	CloseEarly   = "close-early"
+	TruncateBody = "truncate-body"
+	Delay        = "delay"
+	BadHeader    = "bad-header"
+)
+
+var Kinds = []string{BadPath, Abort, CloseEarly, TruncateBody, Delay, BadHeader}
+
+// what a truncated request claims it's sending when it hasn't got a body
+// of its own
+const fillerSize = 4096
+
+// how long to wait for the server to say something back about a fault
+const responseTimeout = 30 * time.Second
+
+// header lines that no server ought to accept, bad-header picks one
+var badHeaders = []string{
+	"X-No-Colon-Here\r\n",
+	"X-Bad Name: space in the name\r\n",
+	" X-Folded: obsolete line folding\r\n",
+	"X-Null: \x00\r\n",
+	"Content-Length: -5\r\n",
+}
+
+type rate struct {
+	kind string
+	pct  float64
+}
+
+type Injector struct {
+	Delay time.Duration // for delay
+
+	// sets up https for the faults that make their own connections, if
+	// it's not just the defaults
+	WrapTLS func(conn net.Conn, host string) (net.Conn, error)
+	// and makes the connection, e.g. for --resolve, nil for a plain dial
+	Dial func(ctx context.Context, network string, addr string) (net.Conn, error)
+
+	mu      sync.Mutex
+	rates   []rate
+	enabled int32 // atomic
+	counts  map[string]*int64
+}
+
+func MakeNew(infoLog *log.Logger) *Injector {
+	INFO = infoLog
+	inj := &Injector{Delay: 5 * time.Second, enabled: 1, counts: make(map[string]*int64)}
+	for _, kind := range Kinds {
+		inj.counts[kind] = new(int64)
+	}
+	return inj
+}
+
+// Add takes a --fault spec, kind:percent
+func (inj *Injector) Add(spec string) error {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("--fault %q s/b kind:percent, e.g. abort:5", spec)
+	}
+	pct, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || pct <= 0 || pct > 100 {
+		return fmt.Errorf("--fault %q s/b a percentage over 0 and up to 100", spec)
+	}
+	return inj.Set(parts[0], pct)
+}
+
+func (inj *Injector) Set(kind string, pct float64) error {
+	if _, ok := inj.counts[kind]; !ok {
+		return fmt.Errorf("unknown fault %q, s/b one of %s", kind, strings.Join(Kinds, ", "))
+	}
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	total := pct
+	for i, r := range inj.rates {
+		if r.kind == kind {
+			inj.rates = append(inj.rates[:i], inj.rates[i+1:]...)
+			break
+		}
+	}
+	for _, r := range inj.rates {
+		total += r.pct
+	}
+	if total > 100 {
+		return fmt.Errorf("the faults add up to %g%%, s/b no more than 100", total)
+	}
+	inj.rates = append(inj.rates, rate{kind, pct})
+	return nil
+}
+
+// Empty is true if there's nothing configured
+func (inj *Injector) Empty() bool {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	return len(inj.rates) == 0
+}
+
+func (inj *Injector) Enabled() bool {
+	return atomic.LoadInt32(&inj.enabled) == 1
+}
+
+func (inj *Injector) SetEnabled(on bool) {
+	if on {
+		atomic.StoreInt32(&inj.enabled, 1)
+	} else {
+		atomic.StoreInt32(&inj.enabled, 0)
+	}
+}
+
+// String is what's configured, e.g. "abort:5% bad-header:1%"
+func (inj *Injector) String() string {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	var parts []string
+	for _, r := range inj.rates {
+		parts = append(parts, fmt.Sprintf("%s:%g%%", r.kind, r.pct))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Pick decides whether this request gets a fault, and which, "" for
+// none. It doesn't count it, that's for Count once it's happened.
+func (inj *Injector) Pick() string {
+	if !inj.Enabled() {
+		return ""
+	}
+	roll := rand.Float64() * 100
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	for _, r := range inj.rates {
+		if roll < r.pct {
+			return r.kind
+		}
+		roll -= r.pct
+	}
+	return ""
+}
+
+// Count is a fault that actually happened, not one Do couldn't carry out
+// because it couldn't connect or the TLS handshake failed
+func (inj *Injector) Count(kind string) {
+	if n, ok := inj.counts[kind]; ok {
+		atomic.AddInt64(n, 1)
+	}
+}
+
+// how many of each kind so far, leaving out the ones that haven't
+// happened
+func (inj *Injector) Counts() map[string]int64 {
+	counts := make(map[string]int64)
+	for kind, n := range inj.counts {
+		if c := atomic.LoadInt64(n); c > 0 {
+			counts[kind] = c
+		}
+	}
+	return counts
+}
+
+// BreakPath is the bad-path fault, the request goes through as normal
+func BreakPath(u *url.URL) {
+	u.Path = "/-artificial-random-failure-" + u.Path
+	u.RawPath = ""
+}
+
+// Do carries out one of the faults that isn't bad-path, returning what the
+// server did about it for the log
+func (inj *Injector) Do(ctx context.Context, kind string, req *http.Request, client *http.Client) (string, error) {
+	if kind == Abort {
+		return abort(ctx, req, client)
+	}
+
+	raw, err := serialize(req, kind == TruncateBody)
+	if err != nil {
+		return "", err
+	}
+	conn, err := inj.dial(ctx, req.URL)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	// the deadline is for the server, the ctx is for us giving up
+	conn.SetDeadline(time.Now().Add(responseTimeout))
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	switch kind {
+	case CloseEarly:
+		_, err := conn.Write(raw)
+		return "closed before the response", err
+
+	case TruncateBody:
+		headerEnd := bytes.Index(raw, []byte("\r\n\r\n")) + 4
+		cut := headerEnd + (len(raw)-headerEnd)/2
+		_, err := conn.Write(raw[:cut])
+		return fmt.Sprintf("closed after %d of %d body bytes", cut-headerEnd, len(raw)-headerEnd), err
+
+	case Delay:
+		headerEnd := bytes.Index(raw, []byte("\r\n\r\n"))
+		if _, err := conn.Write(raw[:headerEnd/2]); err != nil {
+			return "", err
+		}
+		select {
+		case <-time.After(inj.Delay):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		if _, err := conn.Write(raw[headerEnd/2:]); err != nil {
+			return "server hung up during the delay", nil
+		}
+		return readStatus(conn, req)
+
+	case BadHeader:
+		firstLine := bytes.Index(raw, []byte("\r\n")) + 2
+		bad := badHeaders[rand.Intn(len(badHeaders))]
+		withBad := append(append(append([]byte{}, raw[:firstLine]...), bad...), raw[firstLine:]...)
+		if _, err := conn.Write(withBad); err != nil {
+			return "", err
+		}
+		return readStatus(conn, req)
+	}
+	return "", fmt.Errorf("unknown fault %q", kind)
+}
+
+// hang up as soon as the server starts answering
+func abort(ctx context.Context, req *http.Request, client *http.Client) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	trace := &httptrace.ClientTrace{
+		GotFirstResponseByte: cancel,
+	}
+	resp, err := client.Do(req.WithContext(httptrace.WithClientTrace(ctx, trace)))
+	if resp != nil {
+		resp.Body.Close()
+		return "aborted after the response headers", nil
+	}
+	if ctx.Err() != nil {
+		return "aborted at the first byte of the response", nil
+	}
+	return "", err
+}
+
+// the request as it'd go over the wire. For truncate it has to have a
+// body to truncate.
+func serialize(req *http.Request, needBody bool) ([]byte, error) {
+	if needBody && req.ContentLength <= 0 {
+		filler := bytes.Repeat([]byte("x"), fillerSize)
+		req.Body = ioutil.NopCloser(bytes.NewReader(filler))
+		req.ContentLength = fillerSize
+	}
+	var buf bytes.Buffer
+	if err := req.Write(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (inj *Injector) dial(ctx context.Context, u *url.URL) (net.Conn, error) {
+	host := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "https" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+	dial := (&net.Dialer{Timeout: responseTimeout}).DialContext
+	if inj.Dial != nil {
+		dial = inj.Dial
+	}
+	conn, err := dial(ctx, "tcp", host)
+	if err != nil || u.Scheme != "https" {
+		return conn, err
+	}
+	if inj.WrapTLS != nil {
+		tlsConn, err := inj.WrapTLS(conn, u.Hostname())
+		if err != nil {
+			conn.Close()
+		}
+		return tlsConn, err
+	}
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+	tlsConn.SetDeadline(time.Now().Add(responseTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+func readStatus(conn net.Conn, req *http.Request) (string, error) {
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return "no response: " + err.Error(), nil
+	}
+	resp.Body.Close()
+	return resp.Status, nil
+}
@@ -0,0 +1,189 @@
+package faults
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var discard = log.New(ioutil.Discard, "", 0)
+
+func TestAddAndPick(t *testing.T) {
+	inj := MakeNew(discard)
+	if !inj.Empty() {
+		t.Errorf("new injector s/b empty")
+	}
+	for _, bad := range []string{"abort", "abort:0", "abort:x", "abort:101", "frobnicate:5"} {
+		if err := inj.Add(bad); err == nil {
+			t.Errorf("Add(%q) s/b an error", bad)
+		}
+	}
+	if err := inj.Add("abort:20"); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := inj.Add("bad-header:10"); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := inj.Add("delay:71"); err == nil {
+		t.Errorf("faults adding up past 100%% s/b an error")
+	}
+	// setting one again replaces it
+	if err := inj.Add("abort:30"); err != nil {
+		t.Errorf("changing abort's rate failed: %v", err)
+	}
+	if s := inj.String(); s != "bad-header:10% abort:30%" {
+		t.Errorf("String s/b bad-header:10%% abort:30%%, got %q", s)
+	}
+
+	const n = 20000
+	picked := make(map[string]int)
+	for i := 0; i < n; i++ {
+		picked[inj.Pick()]++
+	}
+	for kind, want := range map[string]float64{Abort: 0.3, BadHeader: 0.1, "": 0.6} {
+		got := float64(picked[kind]) / n
+		if got < want-0.02 || got > want+0.02 {
+			t.Errorf("%q s/b picked about %.0f%% of the time, got %.1f%%", kind, want*100, got*100)
+		}
+	}
+	if counts := inj.Counts(); len(counts) != 0 {
+		t.Errorf("picking isn't happening, Counts s/b empty, got %v", counts)
+	}
+	for kind, n := range picked {
+		for i := 0; i < n; i++ {
+			inj.Count(kind)
+		}
+	}
+	counts := inj.Counts()
+	if counts[Abort] != int64(picked[Abort]) || counts[BadHeader] != int64(picked[BadHeader]) {
+		t.Errorf("Counts s/b what got counted, got %v vs %v", counts, picked)
+	}
+	if _, ok := counts[Delay]; ok {
+		t.Errorf("Counts s/b leaving out the ones that never happened, got %v", counts)
+	}
+
+	inj.SetEnabled(false)
+	for i := 0; i < 100; i++ {
+		if kind := inj.Pick(); kind != "" {
+			t.Fatalf("disabled injector s/b picking nothing, got %q", kind)
+		}
+	}
+}
+
+func TestBreakPath(t *testing.T) {
+	u, _ := url.Parse("http://example.com/a/b?x=1")
+	BreakPath(u)
+	if u.String() != "http://example.com/-artificial-random-failure-/a/b?x=1" {
+		t.Errorf("got %s", u)
+	}
+}
+
+// a server that notes how each request turned out
+type recorder struct {
+	mu     sync.Mutex
+	bodies []string
+	errs   []error
+}
+
+func (r *recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	r.mu.Lock()
+	r.bodies = append(r.bodies, string(body))
+	r.errs = append(r.errs, err)
+	r.mu.Unlock()
+	w.Write([]byte("hello"))
+}
+
+func (r *recorder) last(t *testing.T) (string, error) {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		r.mu.Lock()
+		if n := len(r.bodies); n > 0 {
+			defer r.mu.Unlock()
+			return r.bodies[n-1], r.errs[n-1]
+		}
+		r.mu.Unlock()
+		if time.Now().After(deadline) {
+			t.Fatalf("the server never saw the request")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDo(t *testing.T) {
+	rec := &recorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+	inj := MakeNew(discard)
+	inj.Delay = 20 * time.Millisecond
+
+	newReq := func(body string) *http.Request {
+		req, _ := http.NewRequest("POST", srv.URL+"/x", strings.NewReader(body))
+		return req
+	}
+
+	// a malformed header gets turned away before the handler
+	outcome, err := inj.Do(context.Background(), BadHeader, newReq(""), http.DefaultClient)
+	if err != nil || !strings.HasPrefix(outcome, "400") {
+		t.Errorf("bad-header s/b a 400, got %q %v", outcome, err)
+	}
+
+	// a slow one still gets through
+	outcome, err = inj.Do(context.Background(), Delay, newReq("abc"), http.DefaultClient)
+	if err != nil || outcome != "200 OK" {
+		t.Errorf("delay s/b a 200 in the end, got %q %v", outcome, err)
+	}
+	if body, _ := rec.last(t); body != "abc" {
+		t.Errorf("delayed body s/b abc, got %q", body)
+	}
+
+	// the server sees the body end early
+	rec.mu.Lock()
+	rec.bodies, rec.errs = nil, nil
+	rec.mu.Unlock()
+	outcome, err = inj.Do(context.Background(), TruncateBody, newReq(""), http.DefaultClient)
+	if err != nil || outcome != "closed after 2048 of 4096 body bytes" {
+		t.Errorf("truncate-body got %q %v", outcome, err)
+	}
+	if body, readErr := rec.last(t); readErr == nil || len(body) != 2048 {
+		t.Errorf("server s/b got half the body and an error, got %d bytes, %v", len(body), readErr)
+	}
+
+	outcome, err = inj.Do(context.Background(), CloseEarly, newReq(""), http.DefaultClient)
+	if err != nil || outcome != "closed before the response" {
+		t.Errorf("close-early got %q %v", outcome, err)
+	}
+
+	outcome, err = inj.Do(context.Background(), Abort, newReq(""), http.DefaultClient)
+	if err != nil || !strings.HasPrefix(outcome, "aborted") {
+		t.Errorf("abort got %q %v", outcome, err)
+	}
+}
+
+func TestDoCancel(t *testing.T) {
+	srv := httptest.NewServer(&recorder{})
+	defer srv.Close()
+	inj := MakeNew(discard)
+	inj.Delay = time.Minute
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	t0 := time.Now()
+	if _, err := inj.Do(ctx, Delay, req, http.DefaultClient); err == nil {
+		t.Errorf("cancelled delay s/b an error")
+	}
+	if time.Since(t0) > 5*time.Second {
+		t.Errorf("delay s/b cut short by the context")
+	}
+}
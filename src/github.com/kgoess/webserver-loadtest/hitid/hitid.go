@@ -0,0 +1,117 @@
+package hitid
+
+// Correlation ids, so a failure on our end can be found in the server's
+// logs. Every request gets one like
+//
+//	3f9c2a71-web04-12-857
+//
+// which is the run, the node, the worker and which request it was for
+// that worker. Worker ids are never reused within a run, so that's unique
+// across everybody in a distributed run as long as the nodes have
+// different names. Give them all the same --run-id if you want to grep
+// for the whole run at once.
+//
+// The id can go in a query parameter (the old ?hitid=, which does bust
+// caches), a header like X-Request-Id, or nowhere and just in our log.
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	Off    = "off"
+	Query  = "query"
+	Header = "header"
+)
+
+const (
+	DefaultParam  = "hitid"
+	DefaultHeader = "X-Request-Id"
+)
+
+type Tagger struct {
+	Mode  string
+	Name  string // the query param or header
+	RunID string
+	Node  string
+}
+
+// Parse takes the --hitid spec: off, query, query:param, header or
+// header:Name
+func Parse(spec string, runID string, node string) (*Tagger, error) {
+	mode, name := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		mode, name = spec[:i], spec[i+1:]
+		if name == "" {
+			return nil, fmt.Errorf("--hitid %q is missing the name after the colon", spec)
+		}
+	}
+	switch mode {
+	case Off:
+		if name != "" {
+			return nil, fmt.Errorf("--hitid off doesn't take a name")
+		}
+	case Query:
+		if name == "" {
+			name = DefaultParam
+		}
+	case Header:
+		if name == "" {
+			name = DefaultHeader
+		}
+		name = http.CanonicalHeaderKey(name)
+	default:
+		return nil, fmt.Errorf("--hitid %q s/b off, query[:param] or header[:Name]", spec)
+	}
+	if runID == "" {
+		runID = NewRunID()
+	}
+	return &Tagger{Mode: mode, Name: name, RunID: clean(runID), Node: clean(node)}, nil
+}
+
+// eight random hex digits
+func NewRunID() string {
+	var b [4]byte
+	rand.Read(b[:])
+	return fmt.Sprintf("%x", b)
+}
+
+// seq is which request it is for that worker, e.g. "857", or "857.3" for
+// the third step of a --script pass
+func (t *Tagger) ID(worker int, seq string) string {
+	return t.RunID + "-" + t.Node + "-" + strconv.Itoa(worker) + "-" + seq
+}
+
+// Tag puts the id on the request, wherever it's supposed to go
+func (t *Tagger) Tag(req *http.Request, id string) {
+	switch t.Mode {
+	case Query:
+		// tacked on the end rather than through url.Values, which would
+		// put the rest of the query in a different order
+		param := url.QueryEscape(t.Name) + "=" + url.QueryEscape(id)
+		if req.URL.RawQuery == "" {
+			req.URL.RawQuery = param
+		} else {
+			req.URL.RawQuery += "&" + param
+		}
+	case Header:
+		req.Header.Set(t.Name, id)
+	}
+}
+
+// keeps the ids to something that's easy to grep and safe anywhere, the
+// dashes are ours
+func clean(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_':
+			return r
+		}
+		return '_'
+	}, s)
+}
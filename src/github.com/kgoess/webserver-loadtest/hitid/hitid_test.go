@@ -0,0 +1,76 @@
+package hitid
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		spec string
+		mode string
+		name string
+	}{
+		{"off", Off, ""},
+		{"query", Query, "hitid"},
+		{"query:rid", Query, "rid"},
+		{"header", Header, "X-Request-Id"},
+		{"header:x-trace-id", Header, "X-Trace-Id"},
+	} {
+		tagger, err := Parse(tc.spec, "run1", "web04")
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", tc.spec, err)
+			continue
+		}
+		if tagger.Mode != tc.mode || tagger.Name != tc.name {
+			t.Errorf("Parse(%q) s/b %s %q, got %s %q", tc.spec, tc.mode, tc.name, tagger.Mode, tagger.Name)
+		}
+	}
+	for _, bad := range []string{"cookie", "query:", "off:x", ""} {
+		if _, err := Parse(bad, "run1", "web04"); err == nil {
+			t.Errorf("Parse(%q) s/b an error", bad)
+		}
+	}
+}
+
+func TestID(t *testing.T) {
+	tagger, _ := Parse("query", "", "web-04.example.com")
+	if !regexp.MustCompile(`^[0-9a-f]{8}$`).MatchString(tagger.RunID) {
+		t.Errorf("made up run id s/b 8 hex digits, got %q", tagger.RunID)
+	}
+	other, _ := Parse("query", "", "web04")
+	if tagger.RunID == other.RunID {
+		t.Errorf("run ids s/b different each time, got %q twice", tagger.RunID)
+	}
+
+	tagger.RunID = "abc"
+	// the dashes separate the parts, so the node can't have any
+	if id := tagger.ID(12, "857.3"); id != "abc-web_04.example.com-12-857.3" {
+		t.Errorf("id s/b abc-web_04.example.com-12-857.3, got %q", id)
+	}
+}
+
+func TestTag(t *testing.T) {
+	for _, tc := range []struct {
+		spec    string
+		url     string
+		wantUrl string
+		header  string
+	}{
+		{"query", "http://x/a", "http://x/a?hitid=r-n-1-2", ""},
+		{"query:rid", "http://x/a?z=1&b=2", "http://x/a?z=1&b=2&rid=r-n-1-2", ""},
+		{"header", "http://x/a?z=1", "http://x/a?z=1", "r-n-1-2"},
+		{"off", "http://x/a", "http://x/a", ""},
+	} {
+		tagger, _ := Parse(tc.spec, "r", "n")
+		req, _ := http.NewRequest("GET", tc.url, nil)
+		tagger.Tag(req, tagger.ID(1, "2"))
+		if req.URL.String() != tc.wantUrl {
+			t.Errorf("%s: url s/b %s, got %s", tc.spec, tc.wantUrl, req.URL)
+		}
+		if got := req.Header.Get("X-Request-Id"); got != tc.header {
+			t.Errorf("%s: X-Request-Id s/b %q, got %q", tc.spec, tc.header, got)
+		}
+	}
+}
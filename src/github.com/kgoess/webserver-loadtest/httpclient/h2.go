@@ -0,0 +1,213 @@
+package httpclient
+
+// --http2, so the workers multiplex their requests over a few connections
+// like a browser or a gRPC client would, instead of each one having its
+// own HTTP/1.1 connection. https urls need the server to agree to h2 in
+// the TLS handshake, http ones are h2c with prior knowledge (no Upgrade
+// dance, the server has to be expecting it).
+//
+// There's one transport per connection we want (--h2-conns), each one
+// strict about the server's stream limit so it never opens a second
+// connection of its own, and our own cap on the streams each one has
+// going at once (--h2-streams). Requests go to whichever connection is
+// least busy, and wait their turn if they're all full.
+//
+// GOAWAY and RST_STREAM come from the http2 package's error counting,
+// which only sees a GOAWAY with an error code, a graceful one (NO_ERROR)
+// just shows up as a reconnect.
+//
+// Setting up the transports needs Go 1.26, that's in h2config.go, so the
+// rest of the program still builds with an older Go, just without
+// --http2.
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	stats "github.com/kgoess/webserver-loadtest/stats"
+)
+
+type h2Conn struct {
+	t       *http.Transport
+	streams chan struct{} // a slot per stream, nil for no cap of ours
+	active  int64         // atomic
+	hadConn int32         // atomic, so the next new one is a reconnect
+}
+
+type h2Pool struct {
+	conns  []*h2Conn
+	counts *h2Counts
+}
+
+func newH2Pool(base *http.Transport, conns int, streams int, counts *h2Counts) (*h2Pool, error) {
+	if conns < 1 {
+		return nil, fmt.Errorf("--h2-conns s/b at least 1")
+	}
+	if streams < 0 {
+		return nil, fmt.Errorf("--h2-streams s/b 0 for the server's limit, or more")
+	}
+	p := &h2Pool{counts: counts}
+	for i := 0; i < conns; i++ {
+		t, err := h2Transport(base, counts)
+		if err != nil {
+			return nil, err
+		}
+		c := &h2Conn{t: t}
+		if streams > 0 {
+			c.streams = make(chan struct{}, streams)
+		}
+		p.conns = append(p.conns, c)
+	}
+	return p, nil
+}
+
+// the least busy connection
+func (p *h2Pool) pick() *h2Conn {
+	best := p.conns[0]
+	for _, c := range p.conns[1:] {
+		if atomic.LoadInt64(&c.active) < atomic.LoadInt64(&best.active) {
+			best = c
+		}
+	}
+	return best
+}
+
+func (p *h2Pool) RoundTrip(req *http.Request) (*http.Response, error) {
+	c := p.pick()
+	if c.streams != nil {
+		select {
+		case c.streams <- struct{}{}:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	p.counts.peak(atomic.AddInt64(&c.active, 1))
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			atomic.AddInt64(&c.active, -1)
+			if c.streams != nil {
+				<-c.streams
+			}
+		})
+	}
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			reconnect := false
+			if !info.Reused {
+				reconnect = !atomic.CompareAndSwapInt32(&c.hadConn, 0, 1)
+			}
+			p.counts.stream(!info.Reused, reconnect)
+		},
+	}
+	ctx := httptrace.WithClientTrace(req.Context(), trace)
+	resp, err := c.t.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		release()
+		return nil, err
+	}
+	// the stream's still open until the body's been read or closed
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+func (p *h2Pool) CloseIdleConnections() {
+	for _, c := range p.conns {
+		c.t.CloseIdleConnections()
+	}
+}
+
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Read(buf []byte) (int, error) {
+	n, err := b.ReadCloser.Read(buf)
+	if err != nil {
+		b.release()
+	}
+	return n, err
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
+
+type h2Counts struct {
+	mu          sync.Mutex
+	connections int64
+	reconnects  int64
+	streams     int64
+	peakStreams int64
+	goAways     int64
+	resets      int64
+	errors      map[string]int64
+}
+
+func (h *h2Counts) stream(newConn bool, reconnect bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.streams++
+	if newConn {
+		h.connections++
+	}
+	if reconnect {
+		h.reconnects++
+	}
+}
+
+func (h *h2Counts) peak(active int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if active > h.peakStreams {
+		h.peakStreams = active
+	}
+}
+
+// the http2 package calls this with things like "recv_rststream_CANCEL"
+func (h *h2Counts) countError(errType string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch {
+	case strings.HasPrefix(errType, "recv_goaway_"):
+		h.goAways++
+	case strings.HasPrefix(errType, "recv_rststream_"):
+		h.resets++
+	}
+	h.errors[errType]++
+}
+
+// H2Stats is everything so far, nil if it's not --http2
+func (c *Client) H2Stats() *stats.H2Stats {
+	if c.h2 == nil {
+		return nil
+	}
+	h := &c.h2Counts
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := &stats.H2Stats{
+		Connections: h.connections,
+		Reconnects:  h.reconnects,
+		Streams:     h.streams,
+		PeakStreams: h.peakStreams,
+		GoAways:     h.goAways,
+		Resets:      h.resets,
+		Errors:      make(map[string]int64),
+	}
+	if h.connections > 0 {
+		s.StreamsPerConn = float64(h.streams) / float64(h.connections)
+	}
+	for k, v := range h.errors {
+		s.Errors[k] = v
+	}
+	return s
+}
@@ -0,0 +1,146 @@
+//go:build go1.26
+
+package httpclient
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestH2C(t *testing.T) {
+	var mu sync.Mutex
+	remotes := make(map[string]bool)
+	hold := make(chan struct{})
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		remotes[r.RemoteAddr] = true
+		mu.Unlock()
+		if r.URL.Path == "/hold" {
+			<-hold
+		}
+		w.Write([]byte(r.Proto))
+	}))
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	defer srv.Close()
+
+	c, err := MakeNew(Config{HTTP2: true, H2Conns: 2, H2Streams: 3}, discard)
+	if err != nil {
+		t.Fatalf("MakeNew failed: %v", err)
+	}
+	client := c.HTTPClient(nil)
+
+	// more than the 2x3 streams at once, the rest have to wait
+	const n = 10
+	var wg sync.WaitGroup
+	protos := make(chan string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(srv.URL + "/hold")
+			if err != nil {
+				t.Errorf("get failed: %v", err)
+				return
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			protos <- string(body)
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	if s := c.H2Stats(); s.Streams != 6 {
+		t.Errorf("s/b 6 streams going and the rest waiting, got %+v", s)
+	}
+	close(hold)
+	wg.Wait()
+	close(protos)
+	for proto := range protos {
+		if proto != "HTTP/2.0" {
+			t.Errorf("s/b HTTP/2.0, got %s", proto)
+		}
+	}
+
+	s := c.H2Stats()
+	if s.Connections != 2 || len(remotes) != 2 {
+		t.Errorf("s/b 2 connections, got %d, the server saw %d", s.Connections, len(remotes))
+	}
+	if s.Streams != n || s.StreamsPerConn != n/2 {
+		t.Errorf("s/b %d streams, %d per connection, got %+v", n, n/2, s)
+	}
+	if s.PeakStreams != 3 {
+		t.Errorf("s/b 3 streams at most on a connection, got %d", s.PeakStreams)
+	}
+	if s.Reconnects != 0 || s.GoAways != 0 || s.Resets != 0 {
+		t.Errorf("s/b no trouble, got %+v", s)
+	}
+}
+
+func TestH2TLS(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/reset" {
+			panic(http.ErrAbortHandler)
+		}
+		w.Write([]byte(r.Proto))
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	c, err := MakeNew(Config{Insecure: true, HTTP2: true, H2Conns: 1}, discard)
+	if err != nil {
+		t.Fatalf("MakeNew failed: %v", err)
+	}
+	resp, err := c.HTTPClient(nil).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "HTTP/2.0" {
+		t.Errorf("s/b HTTP/2.0, got %s", body)
+	}
+	if s := c.TLSStats(); s == nil || s.Handshakes != 1 {
+		t.Errorf("the handshake s/b counted, got %+v", s)
+	}
+
+	// the server gives up on the stream, not the connection
+	if resp, err := c.HTTPClient(nil).Get(srv.URL + "/reset"); err == nil {
+		_, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err == nil {
+			t.Errorf("a reset stream s/b an error")
+		}
+	}
+	s := c.H2Stats()
+	if s.Resets != 1 || s.Connections != 1 || s.Streams != 2 {
+		t.Errorf("s/b one reset on the one connection, got %+v", s)
+	}
+	if len(s.Errors) != 1 {
+		t.Errorf("s/b the reset in the errors, got %v", s.Errors)
+	}
+}
+
+func TestH2Counts(t *testing.T) {
+	h := &h2Counts{errors: make(map[string]int64)}
+	h.countError("recv_goaway_ENHANCE_YOUR_CALM")
+	h.countError("recv_rststream_REFUSED_STREAM")
+	h.countError("frame_too_large")
+	if h.goAways != 1 || h.resets != 1 || len(h.errors) != 3 {
+		t.Errorf("s/b a goaway, a reset and 3 errors, got %+v", h)
+	}
+
+	for _, cfg := range []Config{{HTTP2: true}, {HTTP2: true, H2Conns: 1, H2Streams: -1}} {
+		if _, err := MakeNew(cfg, discard); err == nil {
+			t.Errorf("%+v s/b an error", cfg)
+		}
+	}
+	if c, _ := MakeNew(Config{}, discard); c.H2Stats() != nil {
+		t.Errorf("H2Stats s/b nil without --http2")
+	}
+}
@@ -0,0 +1,21 @@
+//go:build go1.26
+
+package httpclient
+
+import (
+	"net/http"
+)
+
+// one connection's transport, h2 only (h2c for http urls) and never more
+// streams than the server allows
+func h2Transport(base *http.Transport, counts *h2Counts) (*http.Transport, error) {
+	t := base.Clone()
+	t.Protocols = new(http.Protocols)
+	t.Protocols.SetHTTP2(true)
+	t.Protocols.SetUnencryptedHTTP2(true)
+	t.HTTP2 = &http.HTTP2Config{
+		StrictMaxConcurrentRequests: true,
+		CountError:                  counts.countError,
+	}
+	return t, nil
+}
@@ -0,0 +1,14 @@
+//go:build !go1.26
+
+package httpclient
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// before Go 1.26 the transport can't be made to do h2c and keep to the
+// server's stream limit
+func h2Transport(base *http.Transport, counts *h2Counts) (*http.Transport, error) {
+	return nil, fmt.Errorf("--http2 needs webserver-loadtest built with Go 1.26 or later")
+}
@@ -0,0 +1,388 @@
+package httpclient
+
+// The one http.Transport all the requesters share, so the command line
+// settings for how we connect (TLS and so on) apply to the plain --url
+// requests, the --script steps and the faults alike. It also keeps count
+// of what the connections negotiated.
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+
+	stats "github.com/kgoess/webserver-loadtest/stats"
+)
+
+// debugging kludge--is this really the way to share global loggers?
+var (
+	INFO *log.Logger
+)
+
+type Config struct {
+	// TLS
+	CAFile     string // PEM bundle to trust instead of the system roots
+	CertFile   string // client certificate for mTLS, needs KeyFile
+	KeyFile    string
+	Insecure   bool   // don't verify the server's certificate
+	ServerName string // SNI and verification name, if it's not the url's host
+	MinVersion string // "1.0" to "1.3"
+	MaxVersion string
+	Ciphers    string // comma separated, only for TLS 1.2 and under
+	NoResume   bool   // full handshake on every new connection
+
+	// where to connect
+	Resolve     []string // host:port:ip[,ip...]
+	ResolvePick string   // round-robin (the default) or random
+	SourceIPs   []string // local addresses to take turns connecting from
+	Proxy       string   // http:// or socks5:// url, with any user:pass
+	UnixSocket  string   // connect here instead, whatever the url says
+
+	// HTTP/2
+	HTTP2     bool
+	H2Conns   int // connections per host
+	H2Streams int // most streams going at once on each, 0 for the server's limit
+}
+
+type Client struct {
+	// what the requests go through, with the counting wrapped around it
+	Transport http.RoundTripper
+	// for anything that has to make its own TLS connections
+	TLSConfig *tls.Config
+
+	base     *http.Transport
+	forward  *http.Transport // instead of base for http:// through an http --proxy
+	h2       *h2Pool         // instead of base for --http2
+	tls      tlsCounts
+	resolver *resolver
+	sources  *sources
+	backends backendCounts
+
+	proxy       *proxy
+	proxyCounts proxyCounts
+
+	unixSocket string
+
+	h2Counts h2Counts
+}
+
+func MakeNew(cfg Config, infoLog *log.Logger) (*Client, error) {
+	INFO = infoLog
+	tlsConfig, err := makeTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	resolver, err := newResolver(cfg.Resolve, cfg.ResolvePick)
+	if err != nil {
+		return nil, err
+	}
+	sources, err := newSources(cfg.SourceIPs)
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := parseProxy(cfg.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.UnixSocket != "" && (proxy != nil || resolver.active() || len(sources.addrs) > 0) {
+		return nil, fmt.Errorf("a unix socket can't go with a proxy, --resolve or --source-ip")
+	}
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.TLSClientConfig = tlsConfig
+	if proxy != nil {
+		// ours, not the one from $HTTP_PROXY
+		base.Proxy = nil
+	}
+
+	c := &Client{
+		TLSConfig:  tlsConfig,
+		base:       base,
+		resolver:   resolver,
+		sources:    sources,
+		proxy:      proxy,
+		unixSocket: cfg.UnixSocket,
+	}
+	base.DialContext = c.DialContext
+	if proxy != nil && proxy.url != nil {
+		c.forward = base.Clone()
+		c.forward.Proxy = http.ProxyURL(proxy.url)
+		c.forward.DialContext = c.dialProxy
+	}
+	if cfg.HTTP2 {
+		c.h2Counts.errors = make(map[string]int64)
+		if c.h2, err = newH2Pool(base, cfg.H2Conns, cfg.H2Streams, &c.h2Counts); err != nil {
+			return nil, err
+		}
+	}
+	c.tls.versions = make(map[string]int64)
+	c.tls.ciphers = make(map[string]int64)
+	c.backends.backends = make(map[string]*backend)
+	c.Transport = &countingTransport{c}
+	return c, nil
+}
+
+// an http.Client for one user, jar can be nil
+func (c *Client) HTTPClient(jar http.CookieJar) *http.Client {
+	return &http.Client{Transport: c.Transport, Jar: jar}
+}
+
+func makeTLSConfig(cfg Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.Insecure,
+		ServerName:         cfg.ServerName,
+	}
+	if !cfg.NoResume {
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, fmt.Errorf("a client certificate needs both the cert and the key")
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't load the client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	var err error
+	if tlsConfig.MinVersion, err = parseVersion(cfg.MinVersion); err != nil {
+		return nil, err
+	}
+	if tlsConfig.MaxVersion, err = parseVersion(cfg.MaxVersion); err != nil {
+		return nil, err
+	}
+	if tlsConfig.MaxVersion != 0 && tlsConfig.MinVersion > tlsConfig.MaxVersion {
+		return nil, fmt.Errorf("the min TLS version is over the max")
+	}
+
+	if cfg.Ciphers != "" {
+		if tlsConfig.CipherSuites, err = parseCiphers(cfg.Ciphers); err != nil {
+			return nil, err
+		}
+	}
+	return tlsConfig, nil
+}
+
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// "" is 0, which means the Go default
+func parseVersion(s string) (uint16, error) {
+	if s == "" {
+		return 0, nil
+	}
+	v, ok := versions[strings.TrimPrefix(strings.ToLower(s), "tls")]
+	if !ok {
+		return 0, fmt.Errorf("TLS version %q s/b 1.0, 1.1, 1.2 or 1.3", s)
+	}
+	return v, nil
+}
+
+func versionName(v uint16) string {
+	for name, version := range versions {
+		if version == v {
+			return "TLS" + name
+		}
+	}
+	return fmt.Sprintf("0x%04x", v)
+}
+
+func parseCiphers(list string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[suite.Name] = suite.ID
+	}
+	var ids []uint16
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher %q, s/b a name like TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// counts the handshakes as they happen
+type tlsCounts struct {
+	mu         sync.Mutex
+	handshakes int64
+	failed     int64
+	resumed    int64
+	totalTime  time.Duration
+	maxTime    time.Duration
+	versions   map[string]int64
+	ciphers    map[string]int64
+}
+
+func (t *tlsCounts) record(state tls.ConnectionState, took time.Duration, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		t.failed++
+		return
+	}
+	t.handshakes++
+	t.totalTime += took
+	if took > t.maxTime {
+		t.maxTime = took
+	}
+	if state.DidResume {
+		t.resumed++
+	}
+	t.versions[versionName(state.Version)]++
+	t.ciphers[tls.CipherSuiteName(state.CipherSuite)]++
+}
+
+// TLSStats is everything so far, nil if there haven't been any
+// handshakes
+func (c *Client) TLSStats() *stats.TLSStats {
+	t := &c.tls
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.handshakes == 0 && t.failed == 0 {
+		return nil
+	}
+	s := &stats.TLSStats{
+		Handshakes:     t.handshakes,
+		Failed:         t.failed,
+		Resumed:        t.resumed,
+		HandshakeMaxMs: float64(t.maxTime) / float64(time.Millisecond),
+		Versions:       make(map[string]int64),
+		Ciphers:        make(map[string]int64),
+	}
+	if t.handshakes > 0 {
+		s.HandshakeAvgMs = float64(t.totalTime) / float64(t.handshakes) / float64(time.Millisecond)
+	}
+	for k, v := range t.versions {
+		s.Versions[k] = v
+	}
+	for k, v := range t.ciphers {
+		s.Ciphers[k] = v
+	}
+	return s
+}
+
+// DialContext is how the transport connects, to wherever --resolve says,
+// from the next --source-ip and through the --proxy, or to the unix
+// socket. It's exported for anything that makes its own connections.
+func (c *Client) DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	if c.unixSocket != "" {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "unix", c.unixSocket)
+	}
+	dialer := c.dialer()
+	target := c.resolver.lookup(addr)
+	if c.proxy == nil {
+		return dialer.DialContext(ctx, network, target)
+	}
+
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, network, c.proxy.addr)
+	if err == nil {
+		if err = c.proxy.tunnel(ctx, conn, target); err != nil {
+			conn.Close()
+			conn = nil
+		}
+	}
+	c.countProxy(ctx, time.Since(start), err)
+	return conn, err
+}
+
+// from the next --source-ip
+func (c *Client) dialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		LocalAddr: c.sources.next(),
+	}
+}
+
+// hangs a trace on every request to time the handshakes, and see where
+// it went for the --resolve stats
+type countingTransport struct {
+	c *Client
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var handshakeStart time.Time
+	// the dial can still be going in the background after we've returned
+	var addrMu sync.Mutex
+	var addr string
+	setAddr := func(a string) {
+		addrMu.Lock()
+		addr = a
+		addrMu.Unlock()
+	}
+	trace := &httptrace.ClientTrace{
+		TLSHandshakeStart: func() { handshakeStart = time.Now() },
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			t.c.tls.record(state, time.Since(handshakeStart), err)
+		},
+	}
+	if t.c.resolver.active() {
+		// ConnectStart is for when it never gets connected
+		trace.ConnectStart = func(network, connectAddr string) { setAddr(connectAddr) }
+		trace.GotConn = func(info httptrace.GotConnInfo) { setAddr(info.Conn.RemoteAddr().String()) }
+	}
+	var rt http.RoundTripper = t.c.base
+	switch {
+	case t.c.h2 != nil:
+		rt = t.c.h2
+	case t.c.forwards(req):
+		rt = t.c.forward
+	}
+	start := time.Now()
+	resp, err := rt.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	addrMu.Lock()
+	wentTo := addr
+	addrMu.Unlock()
+	if wentTo != "" {
+		t.c.backends.record(wentTo, time.Since(start), err != nil || resp.StatusCode >= 400)
+	}
+	return resp, err
+}
+
+// WrapTLS is for the faults and the websockets, which talk to the server
+// themselves. It uses the same settings as the transport, but doesn't
+// count.
+func (c *Client) WrapTLS(conn net.Conn, host string) (net.Conn, error) {
+	config := c.TLSConfig.Clone()
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	tlsConn := tls.Client(conn, config)
+	tlsConn.SetDeadline(time.Now().Add(30 * time.Second))
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
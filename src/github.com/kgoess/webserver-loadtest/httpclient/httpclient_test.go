@@ -0,0 +1,232 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var discard = log.New(ioutil.Discard, "", 0)
+
+// the test server's certificate as a --cacert file
+func caFile(t *testing.T, srv *httptest.Server) string {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(path, pemBytes, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func get(c *Client, url string) error {
+	resp, err := c.HTTPClient(nil).Get(url)
+	if err != nil {
+		return err
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	// so the next one needs a new connection
+	c.base.CloseIdleConnections()
+	return nil
+}
+
+func newServer() *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+}
+
+func TestVerify(t *testing.T) {
+	srv := newServer()
+	defer srv.Close()
+
+	c, err := MakeNew(Config{}, discard)
+	if err != nil {
+		t.Fatalf("MakeNew failed: %v", err)
+	}
+	if err := get(c, srv.URL); err == nil {
+		t.Errorf("self-signed cert s/b an error without --cacert")
+	}
+	if s := c.TLSStats(); s == nil || s.Failed != 1 || s.Handshakes != 0 {
+		t.Errorf("s/b one failed handshake, got %+v", s)
+	}
+
+	c, err = MakeNew(Config{CAFile: caFile(t, srv)}, discard)
+	if err != nil {
+		t.Fatalf("MakeNew failed: %v", err)
+	}
+	if err := get(c, srv.URL); err != nil {
+		t.Errorf("s/b trusted with --cacert, got %v", err)
+	}
+
+	// the test cert is for example.com, among others
+	c, _ = MakeNew(Config{CAFile: caFile(t, srv), ServerName: "not-in-the-cert.test"}, discard)
+	if err := get(c, srv.URL); err == nil {
+		t.Errorf("--sni that's not in the cert s/b an error")
+	}
+	c, _ = MakeNew(Config{CAFile: caFile(t, srv), ServerName: "example.com"}, discard)
+	if err := get(c, srv.URL); err != nil {
+		t.Errorf("--sni example.com s/b ok, got %v", err)
+	}
+
+	c, _ = MakeNew(Config{Insecure: true}, discard)
+	if err := get(c, srv.URL); err != nil {
+		t.Errorf("--insecure s/b ok, got %v", err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	srv := newServer()
+	defer srv.Close()
+
+	for _, resume := range []bool{true, false} {
+		c, err := MakeNew(Config{Insecure: true, MaxVersion: "1.2", NoResume: !resume}, discard)
+		if err != nil {
+			t.Fatalf("MakeNew failed: %v", err)
+		}
+		if s := c.TLSStats(); s != nil {
+			t.Errorf("TLSStats s/b nil before any handshakes, got %+v", s)
+		}
+		for i := 0; i < 3; i++ {
+			if err := get(c, srv.URL); err != nil {
+				t.Fatalf("get failed: %v", err)
+			}
+		}
+		s := c.TLSStats()
+		if s.Handshakes != 3 || s.Failed != 0 {
+			t.Errorf("s/b 3 handshakes, got %+v", s)
+		}
+		if s.Versions["TLS1.2"] != 3 {
+			t.Errorf("s/b all TLS1.2, got %v", s.Versions)
+		}
+		if len(s.Ciphers) != 1 {
+			t.Errorf("s/b one cipher, got %v", s.Ciphers)
+		}
+		if s.HandshakeAvgMs <= 0 || s.HandshakeMaxMs < s.HandshakeAvgMs {
+			t.Errorf("handshake times don't add up, avg %g max %g", s.HandshakeAvgMs, s.HandshakeMaxMs)
+		}
+		if resume && s.Resumed != 2 {
+			t.Errorf("s/b resuming after the first, got %d resumed", s.Resumed)
+		}
+		if !resume && s.Resumed != 0 {
+			t.Errorf("s/b no resuming, got %d resumed", s.Resumed)
+		}
+	}
+}
+
+func TestVersionsAndCiphers(t *testing.T) {
+	srv := newServer()
+	defer srv.Close()
+
+	c, _ := MakeNew(Config{Insecure: true, MinVersion: "tls1.3"}, discard)
+	if err := get(c, srv.URL); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if v := c.TLSStats().Versions; v["TLS1.3"] != 1 {
+		t.Errorf("s/b TLS1.3, got %v", v)
+	}
+
+	const cipher = "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"
+	c, _ = MakeNew(Config{Insecure: true, MaxVersion: "1.2", Ciphers: cipher}, discard)
+	if err := get(c, srv.URL); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if ciphers := c.TLSStats().Ciphers; ciphers[cipher] != 1 {
+		t.Errorf("s/b %s, got %v", cipher, ciphers)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	ioutil.WriteFile(empty, []byte("nothing here"), 0644)
+
+	for _, cfg := range []Config{
+		{CAFile: "/no/such/file.pem"},
+		{CAFile: empty},
+		{CertFile: "cert.pem"},
+		{KeyFile: "key.pem"},
+		{CertFile: empty, KeyFile: empty},
+		{MinVersion: "1.4"},
+		{MinVersion: "1.3", MaxVersion: "1.2"},
+		{Ciphers: "TLS_RSA_WITH_AES_128_GCM_SHA256,ROT13"},
+	} {
+		if _, err := MakeNew(cfg, discard); err == nil {
+			t.Errorf("%+v s/b an error", cfg)
+		}
+	}
+}
+
+func TestClientCert(t *testing.T) {
+	// the test server's own cert and key make a fine client cert too
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.Organization[0]))
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	c, _ := MakeNew(Config{Insecure: true}, discard)
+	if err := get(c, srv.URL); err == nil {
+		t.Errorf("s/b an error without a client cert")
+	}
+
+	dir := t.TempDir()
+	cert := srv.TLS.Certificates[0]
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writePEM(t, certFile, "CERTIFICATE", cert.Certificate[0])
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, keyFile, "PRIVATE KEY", keyDER)
+
+	c, err = MakeNew(Config{Insecure: true, CertFile: certFile, KeyFile: keyFile}, discard)
+	if err != nil {
+		t.Fatalf("MakeNew failed: %v", err)
+	}
+	if err := get(c, srv.URL); err != nil {
+		t.Errorf("s/b ok with the client cert, got %v", err)
+	}
+}
+
+func writePEM(t *testing.T, path string, kind string, der []byte) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	pem.Encode(f, &pem.Block{Type: kind, Bytes: der})
+}
+
+func TestWrapTLS(t *testing.T) {
+	srv := newServer()
+	defer srv.Close()
+
+	c, _ := MakeNew(Config{CAFile: caFile(t, srv), ServerName: "example.com"}, discard)
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConn, err := c.WrapTLS(conn, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("WrapTLS s/b using the --sni and --cacert, got %v", err)
+	}
+	defer tlsConn.Close()
+	tlsConn.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+	resp, _ := ioutil.ReadAll(tlsConn)
+	if !strings.HasSuffix(string(resp), "hello") {
+		t.Errorf("s/b hello, got %q", resp)
+	}
+	if s := c.TLSStats(); s != nil {
+		t.Errorf("WrapTLS s/b not counted, got %+v", s)
+	}
+}
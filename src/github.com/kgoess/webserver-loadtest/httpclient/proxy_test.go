@@ -0,0 +1,290 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// joins up the two ends of a tunnel
+func pipe(a, b net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { io.Copy(a, b); a.Close(); wg.Done() }()
+	go func() { io.Copy(b, a); b.Close(); wg.Done() }()
+	wg.Wait()
+}
+
+// an http proxy wanting user:secret that, like squid, only does CONNECT
+// to https, and forwards plain http requests itself
+func connectProxy(t *testing.T, tunnels *[]string, forwards *[]string) *httptest.Server {
+	var mu sync.Mutex
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != "Basic dXNlcjpzZWNyZXQ=" {
+			http.Error(w, "who are you", http.StatusProxyAuthRequired)
+			return
+		}
+		if r.Method != "CONNECT" {
+			if !r.URL.IsAbs() {
+				http.Error(w, "s/b an absolute url", http.StatusBadRequest)
+				return
+			}
+			mu.Lock()
+			*forwards = append(*forwards, r.URL.Host+" "+r.Host)
+			mu.Unlock()
+			out, _ := http.NewRequest(r.Method, r.URL.String(), r.Body)
+			out.Host = r.Host
+			resp, err := http.DefaultTransport.RoundTrip(out)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			defer resp.Body.Close()
+			w.WriteHeader(resp.StatusCode)
+			io.Copy(w, resp.Body)
+			return
+		}
+		if _, port, _ := net.SplitHostPort(r.Host); port == "80" {
+			http.Error(w, "no CONNECT to port 80", http.StatusForbidden)
+			return
+		}
+		mu.Lock()
+		*tunnels = append(*tunnels, r.Host)
+		mu.Unlock()
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		pipe(conn, target)
+	}))
+}
+
+func TestHTTPProxy(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "made it to "+r.Host)
+	})
+	target := httptest.NewServer(handler)
+	defer target.Close()
+	secure := httptest.NewTLSServer(handler)
+	defer secure.Close()
+	var tunnels, forwards []string
+	proxySrv := connectProxy(t, &tunnels, &forwards)
+	defer proxySrv.Close()
+	proxyAddr := proxySrv.Listener.Addr().String()
+
+	c, err := MakeNew(Config{Proxy: "http://user:secret@" + proxyAddr, Insecure: true}, discard)
+	if err != nil {
+		t.Fatalf("MakeNew failed: %v", err)
+	}
+	get := func(url string) (string, *Timing, error) {
+		ctx, timing := WithTiming(context.Background())
+		req, _ := http.NewRequest("GET", url, nil)
+		resp, err := c.HTTPClient(nil).Do(req.WithContext(ctx))
+		if err != nil {
+			return "", timing, err
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return string(body), timing, nil
+	}
+
+	// https goes through a tunnel
+	body, timing, err := get(secure.URL)
+	if err != nil {
+		t.Fatalf("https through the proxy failed: %v", err)
+	}
+	if body != "made it to "+secure.Listener.Addr().String() {
+		t.Errorf("s/b made it, got %q", body)
+	}
+	if len(tunnels) != 1 || tunnels[0] != secure.Listener.Addr().String() || len(forwards) != 0 {
+		t.Errorf("s/b one tunnel to the target, got %v and forwards %v", tunnels, forwards)
+	}
+	if timing.ProxyConnect() <= 0 {
+		t.Errorf("the request's Timing s/b got the proxy time")
+	}
+
+	// plain http goes to the proxy as an absolute url, no CONNECT
+	body, timing, err = get(target.URL)
+	if err != nil {
+		t.Fatalf("http through the proxy failed: %v", err)
+	}
+	if body != "made it to "+target.Listener.Addr().String() {
+		t.Errorf("s/b made it, got %q", body)
+	}
+	if len(tunnels) != 1 || len(forwards) != 1 {
+		t.Errorf("s/b one forward and no more tunnels, got %v and %v", forwards, tunnels)
+	}
+	if timing.ProxyConnect() <= 0 {
+		t.Errorf("the request's Timing s/b got the dial to the proxy")
+	}
+	if s := c.ProxyStats(); s.Connects != 2 || s.Failed != 0 || s.ConnectAvgMs <= 0 {
+		t.Errorf("s/b two connects, got %+v", s)
+	}
+
+	// a --resolve for an http target needs a tunnel to the ip
+	_, port, _ := net.SplitHostPort(target.Listener.Addr().String())
+	c, _ = MakeNew(Config{
+		Proxy:   "http://user:secret@" + proxyAddr,
+		Resolve: []string{"shop.example.com:" + port + ":127.0.0.1"},
+	}, discard)
+	if body, _, err = get("http://shop.example.com:" + port + "/"); err != nil || body != "made it to shop.example.com:"+port {
+		t.Errorf("s/b made it to shop.example.com, got %q %v", body, err)
+	}
+	if len(tunnels) != 2 || tunnels[1] != target.Listener.Addr().String() || len(forwards) != 1 {
+		t.Errorf("s/b tunnelled to %s, got %v and forwards %v", target.Listener.Addr(), tunnels, forwards)
+	}
+
+	c, _ = MakeNew(Config{Proxy: "http://user:wrong@" + proxyAddr, Insecure: true}, discard)
+	if _, err := c.HTTPClient(nil).Get(secure.URL); err == nil {
+		t.Errorf("wrong password s/b an error")
+	}
+	if s := c.ProxyStats(); s.Connects != 0 || s.Failed != 1 {
+		t.Errorf("s/b one failed connect, got %+v", s)
+	}
+	if resp, err := c.HTTPClient(nil).Get(target.URL); err != nil || resp.StatusCode != http.StatusProxyAuthRequired {
+		t.Errorf("wrong password s/b a 407 for plain http, got %v %v", resp, err)
+	}
+}
+
+// a socks5 proxy wanting user:secret, just enough of RFC 1928 and 1929
+func socksProxy(t *testing.T, targets chan<- string) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 512)
+				io.ReadFull(conn, buf[:2])
+				io.ReadFull(conn, buf[:buf[1]])
+				if bytes.IndexByte(buf[:buf[1]], 2) < 0 {
+					conn.Write([]byte{5, 0xff})
+					return
+				}
+				conn.Write([]byte{5, 2})
+				io.ReadFull(conn, buf[:2])
+				user := make([]byte, buf[1])
+				io.ReadFull(conn, user)
+				io.ReadFull(conn, buf[:1])
+				pass := make([]byte, buf[0])
+				io.ReadFull(conn, pass)
+				if string(user) != "user" || string(pass) != "secret" {
+					conn.Write([]byte{1, 1})
+					return
+				}
+				conn.Write([]byte{1, 0})
+
+				io.ReadFull(conn, buf[:4])
+				var host string
+				switch buf[3] {
+				case 1:
+					io.ReadFull(conn, buf[:4])
+					host = net.IP(buf[:4]).String()
+				case 3:
+					io.ReadFull(conn, buf[:1])
+					name := make([]byte, buf[0])
+					io.ReadFull(conn, name)
+					host = string(name)
+				}
+				io.ReadFull(conn, buf[:2])
+				addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf[:2]))))
+				targets <- addr
+				target, err := net.Dial("tcp", addr)
+				if err != nil {
+					conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 80})
+				pipe(conn, target)
+			}()
+		}
+	}()
+	return l
+}
+
+func TestSocksProxy(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "made it")
+	}))
+	defer target.Close()
+	targets := make(chan string, 10)
+	l := socksProxy(t, targets)
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(target.Listener.Addr().String())
+
+	c, err := MakeNew(Config{Proxy: "socks5://user:secret@" + l.Addr().String()}, discard)
+	if err != nil {
+		t.Fatalf("MakeNew failed: %v", err)
+	}
+	// the name goes to the proxy for it to look up
+	resp, err := c.HTTPClient(nil).Get("http://localhost:" + port + "/")
+	if err != nil {
+		t.Fatalf("get through the proxy failed: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "made it" {
+		t.Errorf("s/b made it, got %q", body)
+	}
+	if got := <-targets; got != "localhost:"+port {
+		t.Errorf("proxy s/b asked for localhost:%s, got %s", port, got)
+	}
+
+	// nothing's listening on port 1
+	if _, err := c.HTTPClient(nil).Get("http://127.0.0.1:1/"); err == nil {
+		t.Errorf("s/b an error when the proxy can't connect")
+	}
+	<-targets
+	if s := c.ProxyStats(); s.Connects != 1 || s.Failed != 1 {
+		t.Errorf("s/b one connect and one failed, got %+v", s)
+	}
+
+	c, _ = MakeNew(Config{Proxy: "socks5://user:wrong@" + l.Addr().String()}, discard)
+	if _, err := c.HTTPClient(nil).Get(target.URL); err == nil {
+		t.Errorf("wrong password s/b an error")
+	}
+	c, _ = MakeNew(Config{Proxy: "socks5://" + l.Addr().String()}, discard)
+	if _, err := c.HTTPClient(nil).Get(target.URL); err == nil {
+		t.Errorf("no password s/b an error")
+	}
+}
+
+func TestParseProxy(t *testing.T) {
+	p, err := parseProxy("socks5://bastion")
+	if err != nil || !p.socks || p.addr != "bastion:1080" || p.hasAuth {
+		t.Errorf("s/b socks on 1080 without auth, got %+v %v", p, err)
+	}
+	p, err = parseProxy("http://u:p@egress:3128")
+	if err != nil || p.socks || p.addr != "egress:3128" || p.user != "u" || p.password != "p" {
+		t.Errorf("s/b http on 3128 as u:p, got %+v %v", p, err)
+	}
+	if p, err := parseProxy(""); p != nil || err != nil {
+		t.Errorf("no --proxy s/b nil, got %+v %v", p, err)
+	}
+	for _, bad := range []string{"egress:3128", "https://egress", "ftp://egress", "http://"} {
+		if _, err := parseProxy(bad); err == nil {
+			t.Errorf("parseProxy(%q) s/b an error", bad)
+		}
+	}
+	if c, _ := MakeNew(Config{}, discard); c.ProxyStats() != nil {
+		t.Errorf("ProxyStats s/b nil without --proxy")
+	}
+}
@@ -0,0 +1,157 @@
+package httpclient
+
+// --resolve host:port:ip[,ip...] like curl's, so the requests for a
+// hostname go to the backends we say instead of wherever DNS (or the load
+// balancer) would send them. The url, Host header and SNI don't change,
+// only where the connection goes. With several ips each new connection
+// goes to the next one, or a random one.
+//
+// Once there's a --resolve every request gets counted by the address it
+// went to, so you can see if one backend is slower than the rest.
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	stats "github.com/kgoess/webserver-loadtest/stats"
+)
+
+const (
+	RoundRobin = "round-robin"
+	Random     = "random"
+)
+
+type override struct {
+	ips  []string
+	next uint64 // atomic, for round-robin
+}
+
+type resolver struct {
+	random    bool
+	overrides map[string]*override // by host:port
+}
+
+func newResolver(specs []string, pick string) (*resolver, error) {
+	r := &resolver{overrides: make(map[string]*override)}
+	switch pick {
+	case "", RoundRobin:
+	case Random:
+		r.random = true
+	default:
+		return nil, fmt.Errorf("--resolve-pick %q s/b %s or %s", pick, RoundRobin, Random)
+	}
+	for _, spec := range specs {
+		hostPort, ips, err := parseResolve(spec)
+		if err != nil {
+			return nil, err
+		}
+		r.overrides[hostPort] = &override{ips: ips}
+	}
+	return r, nil
+}
+
+// "shop.example.com:443:10.0.0.1,10.0.0.2" is "shop.example.com:443" and
+// the two ips, ipv6 ones go in brackets like [::1]
+func parseResolve(spec string) (string, []string, error) {
+	parts := strings.SplitN(spec, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		return "", nil, fmt.Errorf("--resolve %q s/b host:port:ip[,ip...]", spec)
+	}
+	if port, err := strconv.Atoi(parts[1]); err != nil || port < 1 || port > 65535 {
+		return "", nil, fmt.Errorf("--resolve %q has a bad port", spec)
+	}
+	var ips []string
+	for _, ip := range strings.Split(parts[2], ",") {
+		ip = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(ip), "["), "]")
+		if net.ParseIP(ip) == nil {
+			return "", nil, fmt.Errorf("--resolve %q: %q isn't an ip address", spec, ip)
+		}
+		ips = append(ips, ip)
+	}
+	return net.JoinHostPort(strings.ToLower(parts[0]), parts[1]), ips, nil
+}
+
+func (r *resolver) active() bool {
+	return len(r.overrides) > 0
+}
+
+func (r *resolver) has(addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	_, ok := r.overrides[net.JoinHostPort(strings.ToLower(host), port)]
+	return ok
+}
+
+// where a connection to addr should really go
+func (r *resolver) lookup(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	o, ok := r.overrides[net.JoinHostPort(strings.ToLower(host), port)]
+	if !ok {
+		return addr
+	}
+	var ip string
+	if r.random {
+		ip = o.ips[rand.Intn(len(o.ips))]
+	} else {
+		ip = o.ips[(atomic.AddUint64(&o.next, 1)-1)%uint64(len(o.ips))]
+	}
+	return net.JoinHostPort(ip, port)
+}
+
+// the requests by the address they went to
+type backendCounts struct {
+	mu       sync.Mutex
+	backends map[string]*backend
+}
+
+type backend struct {
+	requests  int64
+	fails     int64
+	totalTime time.Duration
+}
+
+func (b *backendCounts) record(addr string, took time.Duration, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	be, ok := b.backends[addr]
+	if !ok {
+		be = &backend{}
+		b.backends[addr] = be
+	}
+	be.requests++
+	be.totalTime += took
+	if failed {
+		be.fails++
+	}
+}
+
+// BackendStats is everything so far by the address the requests went to,
+// nil if there's no --resolve
+func (c *Client) BackendStats() map[string]*stats.BackendStats {
+	if !c.resolver.active() {
+		return nil
+	}
+	b := &c.backends
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	all := make(map[string]*stats.BackendStats)
+	for addr, be := range b.backends {
+		all[addr] = &stats.BackendStats{
+			Requests: be.requests,
+			Fails:    be.fails,
+			AvgMs:    float64(be.totalTime) / float64(be.requests) / float64(time.Millisecond),
+		}
+	}
+	return all
+}
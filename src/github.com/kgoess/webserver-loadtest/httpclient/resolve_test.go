@@ -0,0 +1,129 @@
+package httpclient
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestParseResolve(t *testing.T) {
+	hostPort, ips, err := parseResolve("Shop.Example.com:443:10.0.0.1, 10.0.0.2,[::1]")
+	if err != nil {
+		t.Fatalf("parseResolve failed: %v", err)
+	}
+	if hostPort != "shop.example.com:443" {
+		t.Errorf("s/b shop.example.com:443, got %s", hostPort)
+	}
+	if fmt.Sprint(ips) != "[10.0.0.1 10.0.0.2 ::1]" {
+		t.Errorf("s/b the three ips, got %v", ips)
+	}
+
+	for _, bad := range []string{
+		"shop.example.com",
+		"shop.example.com:443",
+		"shop.example.com:443:",
+		":443:10.0.0.1",
+		"shop.example.com:https:10.0.0.1",
+		"shop.example.com:0:10.0.0.1",
+		"shop.example.com:443:10.0.0.1,backend2",
+	} {
+		if _, _, err := parseResolve(bad); err == nil {
+			t.Errorf("parseResolve(%q) s/b an error", bad)
+		}
+	}
+	if _, err := newResolver(nil, "fastest"); err == nil {
+		t.Errorf("unknown --resolve-pick s/b an error")
+	}
+}
+
+// two backends on the same port, 127.0.0.1 and 127.0.0.2, which say who
+// they are and what Host they got
+func backends(t *testing.T) (string, []*httptest.Server) {
+	var servers []*httptest.Server
+	port := "0"
+	for i, ip := range []string{"127.0.0.1", "127.0.0.2"} {
+		l, err := net.Listen("tcp", net.JoinHostPort(ip, port))
+		if err != nil {
+			t.Skipf("can't listen on %s: %v", ip, err)
+		}
+		_, port, _ = net.SplitHostPort(l.Addr().String())
+		name := strconv.Itoa(i + 1)
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/fail" && name == "2" {
+				http.Error(w, "sick", http.StatusInternalServerError)
+				return
+			}
+			fmt.Fprintf(w, "%s %s", name, r.Host)
+		}))
+		srv.Listener.Close()
+		srv.Listener = l
+		srv.Start()
+		servers = append(servers, srv)
+	}
+	return port, servers
+}
+
+func TestResolve(t *testing.T) {
+	port, servers := backends(t)
+	for _, srv := range servers {
+		defer srv.Close()
+	}
+	c, err := MakeNew(Config{Resolve: []string{"shop.test:" + port + ":127.0.0.1,127.0.0.2"}}, discard)
+	if err != nil {
+		t.Fatalf("MakeNew failed: %v", err)
+	}
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		resp, err := c.HTTPClient(nil).Get("http://shop.test:" + port + "/fail")
+		if err != nil {
+			t.Fatalf("get failed: %v", err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		got = append(got, string(body))
+		c.base.CloseIdleConnections()
+	}
+	ok := "1 shop.test:" + port
+	if len(got) != 4 || got[0] != ok || got[1] != "sick\n" || got[2] != ok || got[3] != "sick\n" {
+		t.Errorf("s/b alternating with the Host header kept, got %q", got)
+	}
+
+	all := c.BackendStats()
+	one, two := all["127.0.0.1:"+port], all["127.0.0.2:"+port]
+	if len(all) != 2 || one == nil || two == nil {
+		t.Fatalf("s/b stats for both backends, got %v", all)
+	}
+	if one.Requests != 2 || one.Fails != 0 || two.Requests != 2 || two.Fails != 2 {
+		t.Errorf("s/b 2 ok on .1 and 2 failed on .2, got %+v %+v", one, two)
+	}
+
+	// other hosts are left alone
+	if _, err := c.HTTPClient(nil).Get("http://localhost:" + port + "/"); err != nil {
+		t.Errorf("localhost s/b dialed as usual, got %v", err)
+	}
+}
+
+func TestResolveRandom(t *testing.T) {
+	r, err := newResolver([]string{"shop.test:80:10.0.0.1,10.0.0.2"}, Random)
+	if err != nil {
+		t.Fatalf("newResolver failed: %v", err)
+	}
+	seen := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		seen[r.lookup("SHOP.test:80")]++
+	}
+	if len(seen) != 2 || seen["10.0.0.1:80"] < 20 || seen["10.0.0.2:80"] < 20 {
+		t.Errorf("s/b both about half the time, got %v", seen)
+	}
+	if addr := r.lookup("shop.test:443"); addr != "shop.test:443" {
+		t.Errorf("other port s/b left alone, got %s", addr)
+	}
+	if c, _ := MakeNew(Config{}, discard); c.BackendStats() != nil {
+		t.Errorf("BackendStats s/b nil without --resolve")
+	}
+}
@@ -0,0 +1,52 @@
+package httpclient
+
+// --source-ip, for load boxes with several addresses: each new connection
+// comes from the next one in turn. Every source ip gets its own ~28000
+// ephemeral ports to every server address, so spreading the connections
+// over them puts off running out when there's a lot of churn, and the
+// server sees several clients, for trying out per-ip rate limits.
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+)
+
+type sources struct {
+	addrs []*net.TCPAddr
+	turn  uint64 // atomic
+}
+
+// ips can be given one at a time or comma separated
+func newSources(ips []string) (*sources, error) {
+	s := &sources{}
+	for _, list := range ips {
+		for _, ip := range strings.Split(list, ",") {
+			ip = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(ip), "["), "]")
+			parsed := net.ParseIP(ip)
+			if parsed == nil {
+				return nil, fmt.Errorf("--source-ip %q isn't an ip address", ip)
+			}
+			addr := &net.TCPAddr{IP: parsed}
+			// better to find out now than on every connection
+			l, err := net.ListenTCP("tcp", addr)
+			if err != nil {
+				return nil, fmt.Errorf("--source-ip %s doesn't look like one of ours: %v", ip, err)
+			}
+			l.Close()
+			s.addrs = append(s.addrs, addr)
+		}
+	}
+	return s, nil
+}
+
+// the address for the next connection, nil to let the OS pick. The
+// dialer only tries server addresses of the same kind (v4 or v6) as the
+// source, so an ipv4 --source-ip can't reach an ipv6-only server.
+func (s *sources) next() net.Addr {
+	if len(s.addrs) == 0 {
+		return nil
+	}
+	return s.addrs[(atomic.AddUint64(&s.turn, 1)-1)%uint64(len(s.addrs))]
+}
@@ -0,0 +1,50 @@
+package httpclient
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSourceIPs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, _ := net.SplitHostPort(r.RemoteAddr)
+		fmt.Fprint(w, ip)
+	}))
+	defer srv.Close()
+
+	c, err := MakeNew(Config{SourceIPs: []string{"127.0.0.1,127.0.0.2", "127.0.0.3"}}, discard)
+	if err != nil {
+		t.Skipf("can't use the loopback addresses: %v", err)
+	}
+	var got []string
+	for i := 0; i < 4; i++ {
+		resp, err := c.HTTPClient(nil).Get(srv.URL)
+		if err != nil {
+			t.Fatalf("get failed: %v", err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		got = append(got, string(body))
+		c.base.CloseIdleConnections()
+	}
+	if fmt.Sprint(got) != "[127.0.0.1 127.0.0.2 127.0.0.3 127.0.0.1]" {
+		t.Errorf("s/b taking turns, got %v", got)
+	}
+}
+
+func TestSourceIPErrors(t *testing.T) {
+	// 192.0.2.0/24 is for documentation, it won't be on this box
+	for _, bad := range []string{"localhost", "127.0.0.1,", "192.0.2.1"} {
+		if _, err := newSources([]string{bad}); err == nil {
+			t.Errorf("--source-ip %q s/b an error", bad)
+		}
+	}
+	s, err := newSources(nil)
+	if err != nil || s.next() != nil {
+		t.Errorf("no --source-ip s/b letting the OS pick, got %v %v", s.next(), err)
+	}
+}
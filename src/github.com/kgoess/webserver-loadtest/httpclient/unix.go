@@ -0,0 +1,39 @@
+package httpclient
+
+// unix: targets, for benchmarking an app server on its upstream socket
+// without the TCP stack in the way. The --url is the socket and then the
+// request's path after a colon,
+//
+//	unix:///run/app.sock:/api/items?id=3
+//
+// and it gets turned into an http:// url for the requests with the Host
+// header we want to send as its host, while the dialing all goes to the
+// socket whatever the url says.
+
+import (
+	"fmt"
+	"strings"
+)
+
+const unixPrefix = "unix://"
+
+func IsUnixURL(raw string) bool {
+	return strings.HasPrefix(raw, unixPrefix)
+}
+
+// ParseUnixURL splits a unix: --url into the socket and the request path,
+// which is "/" if there isn't one
+func ParseUnixURL(raw string) (socket string, path string, err error) {
+	rest := strings.TrimPrefix(raw, unixPrefix)
+	socket, path = rest, "/"
+	if i := strings.Index(rest, ":"); i >= 0 {
+		socket, path = rest[:i], rest[i+1:]
+	}
+	if !strings.HasPrefix(socket, "/") {
+		return "", "", fmt.Errorf("%q s/b unix:///path/to.sock, with an absolute path, then :/request/path if you want", raw)
+	}
+	if !strings.HasPrefix(path, "/") {
+		return "", "", fmt.Errorf("the request path in %q s/b like :/some/path", raw)
+	}
+	return socket, path, nil
+}
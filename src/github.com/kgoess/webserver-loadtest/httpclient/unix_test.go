@@ -0,0 +1,68 @@
+package httpclient
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseUnixURL(t *testing.T) {
+	for _, tc := range []struct {
+		raw    string
+		socket string
+		path   string
+	}{
+		{"unix:///run/app.sock", "/run/app.sock", "/"},
+		{"unix:///run/app.sock:/api/items?id={{rand_int 1 9}}", "/run/app.sock", "/api/items?id={{rand_int 1 9}}"},
+	} {
+		socket, path, err := ParseUnixURL(tc.raw)
+		if err != nil || socket != tc.socket || path != tc.path {
+			t.Errorf("%s s/b %s and %s, got %s %s %v", tc.raw, tc.socket, tc.path, socket, path, err)
+		}
+	}
+	for _, bad := range []string{"unix://run/app.sock", "unix:///run/app.sock:api", "unix://"} {
+		if _, _, err := ParseUnixURL(bad); err == nil {
+			t.Errorf("ParseUnixURL(%q) s/b an error", bad)
+		}
+	}
+	if IsUnixURL("http://localhost/") || !IsUnixURL("unix:///x") {
+		t.Errorf("IsUnixURL is wrong")
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "app.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("no unix sockets: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.Host, r.URL)
+	}))
+	srv.Listener.Close()
+	srv.Listener = l
+	srv.Start()
+	defer srv.Close()
+
+	c, err := MakeNew(Config{UnixSocket: socket}, discard)
+	if err != nil {
+		t.Fatalf("MakeNew failed: %v", err)
+	}
+	resp, err := c.HTTPClient(nil).Get("http://app.internal/api?x=1")
+	if err != nil {
+		t.Fatalf("get over the socket failed: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "app.internal /api?x=1" {
+		t.Errorf("s/b the Host and path from the url, got %q", body)
+	}
+
+	if _, err := MakeNew(Config{UnixSocket: socket, Proxy: "socks5://bastion"}, discard); err == nil {
+		t.Errorf("unix socket with a proxy s/b an error")
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+// What the keys do, shared by the curses and text frontEnds. The
+// runloops only read keys and send on channels (or flip the atomics in
+// liveControls, or pause the requesters), they don't draw anything.
+
+import (
+	"sync/atomic"
+)
+
+const helpText = `q             quit
+e             the event log (up/down to scroll, f to filter)
++ = s  up     one more worker
+-      down   one fewer worker
+]      pgup   ten more workers
+[      pgdn   ten fewer workers
+t 123 enter   exactly 123 workers
+p             pause/resume all the workers
+r             reset the stats
+f             turn the --fault injection on/off
+v             switch the chart (req/s, fails)
+left right    scroll the chart back/forward
+home end      to the start of the run/back to live
+z Z           zoom out/in
+?             this help`
+
+// --ui=text has no event log or chart, and no pgup/pgdn
+const textHelpText = `q             quit
++ = s  up     one more worker
+-      down   one fewer worker
+]             ten more workers
+[             ten fewer workers
+t 123 enter   exactly 123 workers
+p             pause/resume all the workers
+r             reset the stats
+f             turn the --fault injection on/off
+?             this help`
+
+// settings the keys can change while the requesters are running, only
+// touch these with sync/atomic
+type liveControls struct {
+	workers int32 // how many requesters we've asked for
+}
+
+var controls liveControls
+
+// for the keys that move more than one at a time, or to an exact number
+func changeThreadsTo(
+	infoMsgsCh chan<- ncursesMsg,
+	changeNumRequestersCh chan<- interface{},
+	target int,
+) {
+	if target < 0 {
+		target = 0
+	}
+	threadCount := int(atomic.SwapInt32(&controls.workers, int32(target)))
+	INFO.Println("changing threads from ", threadCount, " to ", target)
+	infoMsgsCh <- ncursesMsg{"changing threads", target, MSG_TYPE_INFO, ""}
+	// the requesterController and the slaves only understand one at a time
+	for ; threadCount < target; threadCount++ {
+		changeNumRequestersCh <- 1
+	}
+	for ; threadCount > target; threadCount-- {
+		changeNumRequestersCh <- -1
+	}
+}
+
+func changeThreadsBy(
+	infoMsgsCh chan<- ncursesMsg,
+	changeNumRequestersCh chan<- interface{},
+	delta int,
+) {
+	changeThreadsTo(infoMsgsCh, changeNumRequestersCh, int(atomic.LoadInt32(&controls.workers))+delta)
+}
+
+func togglePause(infoMsgsCh chan<- ncursesMsg) {
+	if !requesters.Paused() {
+		requesters.Pause()
+		INFO.Println("pausing the requesters")
+		infoMsgsCh <- ncursesMsg{"paused, p to resume", -1, MSG_TYPE_INFO, ""}
+	} else {
+		requesters.Resume()
+		INFO.Println("resuming the requesters")
+		infoMsgsCh <- ncursesMsg{"resumed", -1, MSG_TYPE_INFO, ""}
+	}
+}
+
+// toggles the --fault settings, or --random-fails (3/10 if that wasn't
+// given either)
+func toggleFaults(infoMsgsCh chan<- ncursesMsg) {
+	if faultInjector.Enabled() {
+		faultInjector.SetEnabled(false)
+		INFO.Println("faults off")
+		infoMsgsCh <- ncursesMsg{"faults off", -1, MSG_TYPE_INFO, ""}
+	} else {
+		faultInjector.SetEnabled(true)
+		INFO.Println("faults on: ", faultInjector)
+		infoMsgsCh <- ncursesMsg{"faults on: " + faultInjector.String(), -1, MSG_TYPE_INFO, ""}
+	}
+}
+
+func resetStats(infoMsgsCh chan<- ncursesMsg, resetStatsCh chan<- bool) {
+	infoMsgsCh <- ncursesMsg{"resetting stats", -1, MSG_TYPE_INFO, ""}
+	resetStatsCh <- true
+}
+
+// collects up the digits after a 't', returns true when it's got a
+// whole number (on enter)
+type targetEntry struct {
+	active bool
+	digits string
+}
+
+func (e *targetEntry) start(infoMsgsCh chan<- ncursesMsg) {
+	e.active = true
+	e.digits = ""
+	infoMsgsCh <- ncursesMsg{"how many workers? (enter)", -1, MSG_TYPE_PROMPT, ""}
+}
+
+func (e *targetEntry) key(c int, infoMsgsCh chan<- ncursesMsg) (target int, done bool) {
+	switch {
+	case c >= '0' && c <= '9' && len(e.digits) < 6:
+		e.digits += string(rune(c))
+		infoMsgsCh <- ncursesMsg{"how many workers? " + e.digits, -1, MSG_TYPE_PROMPT, ""}
+	case (c == '\n' || c == '\r') && e.digits != "":
+		e.active = false
+		for _, d := range e.digits {
+			target = target*10 + int(d-'0')
+		}
+		return target, true
+	case c == 127 || c == 8: // backspace
+		if e.digits != "" {
+			e.digits = e.digits[:len(e.digits)-1]
+		}
+		infoMsgsCh <- ncursesMsg{"how many workers? " + e.digits, -1, MSG_TYPE_PROMPT, ""}
+	default:
+		// anything else gives up
+		e.active = false
+		infoMsgsCh <- ncursesMsg{"never mind", -1, MSG_TYPE_INFO, ""}
+	}
+	return 0, false
+}
@@ -0,0 +1,315 @@
+package metrics
+
+// Just enough of the Prometheus text exposition format to get our numbers
+// into Grafana, without pulling in the whole client library. See
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// a good spread for web requests, in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+func MakeNew() *Registry {
+	return new(Registry)
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	r.metrics = append(r.metrics, m)
+	r.mu.Unlock()
+}
+
+// WriteText dumps everything in the text exposition format
+func (r *Registry) WriteText(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range r.metrics {
+		m.write(w)
+	}
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.WriteText(w)
+}
+
+// the common bits for all the *Vec types, a metric name plus one child per
+// distinct set of label values
+type vec struct {
+	mu         sync.Mutex
+	name       string
+	help       string
+	typ        string
+	labelNames []string
+	children   map[string]interface{}
+	keys       []string // so the output is in a stable order
+}
+
+func makeVec(name, help, typ string, labelNames []string) vec {
+	return vec{
+		name:       name,
+		help:       help,
+		typ:        typ,
+		labelNames: labelNames,
+		children:   make(map[string]interface{}),
+	}
+}
+
+// returns the child for those label values, calling makeChild if it's new
+func (v *vec) child(labelValues []string, makeChild func() interface{}) interface{} {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("%s wants %d label values, got %d", v.name, len(v.labelNames), len(labelValues)))
+	}
+	key := v.labelString(labelValues, "")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c, ok := v.children[key]
+	if !ok {
+		c = makeChild()
+		v.children[key] = c
+		v.keys = append(v.keys, key)
+		sort.Strings(v.keys)
+	}
+	return c
+}
+
+// turns the label values into {a="x",b="y"}, with extra (e.g. le="0.5")
+// tacked on the end if it's not empty
+func (v *vec) labelString(labelValues []string, extra string) string {
+	parts := make([]string, 0, len(labelValues)+1)
+	for i, val := range labelValues {
+		parts = append(parts, v.labelNames[i]+"="+quoteLabel(val))
+	}
+	if extra != "" {
+		parts = append(parts, extra)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// the exposition format only escapes backslash, double quote and newline,
+// everything else (UTF-8 included) goes in as is, which strconv.Quote
+// doesn't do
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(val string) string {
+	return `"` + labelEscaper.Replace(val) + `"`
+}
+
+func (v *vec) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.typ)
+}
+
+// we need the label values back when writing histograms, so we don't
+// have to parse them out of the key again
+type labelled struct {
+	labelValues []string
+}
+
+//
+// counters
+//
+
+type Counter struct {
+	labelled
+	mu  sync.Mutex
+	val float64
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("counters can't go down")
+	}
+	c.mu.Lock()
+	c.val += delta
+	c.mu.Unlock()
+}
+
+func (c *Counter) Value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.val
+}
+
+type CounterVec struct {
+	vec
+}
+
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	cv := &CounterVec{makeVec(name, help, "counter", labelNames)}
+	r.register(cv)
+	return cv
+}
+
+func (cv *CounterVec) WithLabels(labelValues ...string) *Counter {
+	return cv.child(labelValues, func() interface{} {
+		return &Counter{labelled: labelled{labelValues}}
+	}).(*Counter)
+}
+
+func (cv *CounterVec) write(w io.Writer) {
+	cv.writeHeader(w)
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+	for _, key := range cv.keys {
+		fmt.Fprintf(w, "%s%s %s\n", cv.name, key, formatFloat(cv.children[key].(*Counter).Value()))
+	}
+}
+
+//
+// gauges
+//
+
+type Gauge struct {
+	labelled
+	mu  sync.Mutex
+	val float64
+}
+
+func (g *Gauge) Set(val float64) {
+	g.mu.Lock()
+	g.val = val
+	g.mu.Unlock()
+}
+
+func (g *Gauge) Add(delta float64) {
+	g.mu.Lock()
+	g.val += delta
+	g.mu.Unlock()
+}
+
+func (g *Gauge) Value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.val
+}
+
+type GaugeVec struct {
+	vec
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	gv := &GaugeVec{makeVec(name, help, "gauge", labelNames)}
+	r.register(gv)
+	return gv
+}
+
+func (gv *GaugeVec) WithLabels(labelValues ...string) *Gauge {
+	return gv.child(labelValues, func() interface{} {
+		return &Gauge{labelled: labelled{labelValues}}
+	}).(*Gauge)
+}
+
+func (gv *GaugeVec) write(w io.Writer) {
+	gv.writeHeader(w)
+	gv.mu.Lock()
+	defer gv.mu.Unlock()
+	for _, key := range gv.keys {
+		fmt.Fprintf(w, "%s%s %s\n", gv.name, key, formatFloat(gv.children[key].(*Gauge).Value()))
+	}
+}
+
+//
+// histograms
+//
+
+type Histogram struct {
+	labelled
+	mu      sync.Mutex
+	buckets []float64 // upper bounds, not including +Inf
+	counts  []uint64  // not cumulative, we add them up when writing
+	count   uint64
+	sum     float64
+}
+
+func (h *Histogram) Observe(val float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, upper := range h.buckets {
+		if val <= upper {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += val
+}
+
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	hv := &HistogramVec{makeVec(name, help, "histogram", labelNames), buckets}
+	r.register(hv)
+	return hv
+}
+
+func (hv *HistogramVec) WithLabels(labelValues ...string) *Histogram {
+	return hv.child(labelValues, func() interface{} {
+		return &Histogram{
+			labelled: labelled{labelValues},
+			buckets:  hv.buckets,
+			counts:   make([]uint64, len(hv.buckets)),
+		}
+	}).(*Histogram)
+}
+
+func (hv *HistogramVec) write(w io.Writer) {
+	hv.writeHeader(w)
+	hv.mu.Lock()
+	defer hv.mu.Unlock()
+	for _, key := range hv.keys {
+		h := hv.children[key].(*Histogram)
+		h.mu.Lock()
+		var buf bytes.Buffer
+		cumulative := uint64(0)
+		for i, upper := range h.buckets {
+			cumulative += h.counts[i]
+			le := "le=" + quoteLabel(formatFloat(upper))
+			fmt.Fprintf(&buf, "%s_bucket%s %d\n", hv.name, hv.labelString(h.labelValues, le), cumulative)
+		}
+		fmt.Fprintf(&buf, "%s_bucket%s %d\n", hv.name, hv.labelString(h.labelValues, `le="+Inf"`), h.count)
+		fmt.Fprintf(&buf, "%s_sum%s %s\n", hv.name, key, formatFloat(h.sum))
+		fmt.Fprintf(&buf, "%s_count%s %d\n", hv.name, key, h.count)
+		h.mu.Unlock()
+		w.Write(buf.Bytes())
+	}
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
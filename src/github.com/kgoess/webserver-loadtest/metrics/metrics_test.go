@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCounterAndGauge(t *testing.T) {
+	r := MakeNew()
+	reqs := r.NewCounterVec("test_requests_total", "requests made", "url", "node")
+	workers := r.NewGaugeVec("test_workers", "active workers", "node")
+
+	reqs.WithLabels("http://a/", "box1").Inc()
+	reqs.WithLabels("http://a/", "box1").Add(2)
+	reqs.WithLabels("http://b/", "box1").Inc()
+	workers.WithLabels("box1").Add(5)
+	workers.WithLabels("box1").Add(-2)
+
+	var buf bytes.Buffer
+	r.WriteText(&buf)
+	got := buf.String()
+
+	for _, want := range []string{
+		"# HELP test_requests_total requests made\n",
+		"# TYPE test_requests_total counter\n",
+		`test_requests_total{url="http://a/",node="box1"} 3` + "\n",
+		`test_requests_total{url="http://b/",node="box1"} 1` + "\n",
+		"# TYPE test_workers gauge\n",
+		`test_workers{node="box1"} 3` + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	r := MakeNew()
+	lat := r.NewHistogramVec("test_latency_seconds", "latency", []float64{0.1, 1}, "node")
+
+	h := lat.WithLabels("box1")
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(0.5)
+	h.Observe(3)
+
+	var buf bytes.Buffer
+	r.WriteText(&buf)
+	got := buf.String()
+
+	for _, want := range []string{
+		"# TYPE test_latency_seconds histogram\n",
+		`test_latency_seconds_bucket{node="box1",le="0.1"} 1` + "\n",
+		`test_latency_seconds_bucket{node="box1",le="1"} 3` + "\n",
+		`test_latency_seconds_bucket{node="box1",le="+Inf"} 4` + "\n",
+		`test_latency_seconds_sum{node="box1"} 4.05` + "\n",
+		`test_latency_seconds_count{node="box1"} 4` + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	r := MakeNew()
+	reqs := r.NewCounterVec("test_total", "whatever", "url")
+	reqs.WithLabels("http://exämple/日本").Inc()
+	reqs.WithLabels("a\\b \"c\"\nd\te").Inc()
+
+	var buf bytes.Buffer
+	r.WriteText(&buf)
+	got := buf.String()
+
+	for _, want := range []string{
+		`test_total{url="http://exämple/日本"} 1` + "\n",
+		`test_total{url="a\\b \"c\"\nd` + "\t" + `e"} 1` + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestLabelCountMismatchPanics(t *testing.T) {
+	r := MakeNew()
+	reqs := r.NewCounterVec("test_total", "whatever", "url")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("wrong number of label values s/b a panic")
+		}
+	}()
+	reqs.WithLabels("a", "b")
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := MakeNew()
+	r.NewCounterVec("test_total", "whatever").WithLabels().Inc()
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(rec.Body)
+	if !strings.Contains(string(body), "test_total 1\n") {
+		t.Errorf("unlabelled counter missing, got:\n%s", body)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("content-type s/b text/plain, got %s", ct)
+	}
+}
@@ -0,0 +1,114 @@
+package ringbuffer
+
+import "sync"
+
+// A History is a ringbuffer that isn't tied to the wall clock, you just
+// Push a value every second and it remembers the last capacity of them.
+// The collector pushes and the displays read, hence the lock.
+type History struct {
+	mu    sync.Mutex
+	array []int64
+	next  int // where the next Push goes
+	count int // how many we've got, up to len(array)
+}
+
+func MakeNewHistory(capacity int) *History {
+	h := new(History)
+	h.array = make([]int64, capacity)
+	return h
+}
+
+func (h *History) Push(val int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.array[h.next] = val
+	h.next++
+	if h.next >= len(h.array) {
+		h.next = 0
+	}
+	if h.count < len(h.array) {
+		h.count++
+	}
+}
+
+func (h *History) Clear() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.next = 0
+	h.count = 0
+}
+
+func (h *History) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func (h *History) Capacity() int {
+	return len(h.array)
+}
+
+// Last returns up to the n most recent values, oldest first
+func (h *History) Last(n int) []int64 {
+	return h.Range(0, n)
+}
+
+// Range is like Last, but ending skip values before the most recent one,
+// for scrolling back through the history
+func (h *History) Range(skip int, n int) []int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if skip > h.count {
+		skip = h.count
+	}
+	if skip < 0 {
+		skip = 0
+	}
+	if n > h.count-skip {
+		n = h.count - skip
+	}
+	if n < 0 {
+		n = 0
+	}
+	vals := make([]int64, n)
+	start := h.next - skip - n
+	for start < 0 {
+		start += len(h.array)
+	}
+	for i := 0; i < n; i++ {
+		vals[i] = h.array[(start+i)%len(h.array)]
+	}
+	return vals
+}
+
+// Aggregate sums every per values into one, for zooming out. The groups
+// are counted from the newest end, so if len(vals) isn't a multiple of per
+// it's the oldest one that comes up short.
+func Aggregate(vals []int64, per int) []int64 {
+	if per <= 1 {
+		return vals
+	}
+	sums := make([]int64, (len(vals)+per-1)/per)
+	for i := range vals {
+		fromEnd := len(vals) - 1 - i
+		sums[len(sums)-1-fromEnd/per] += vals[i]
+	}
+	return sums
+}
+
+// AggregateMax is Aggregate but keeping the biggest of each group, for
+// things like latency where adding them up doesn't mean anything
+func AggregateMax(vals []int64, per int) []int64 {
+	if per <= 1 {
+		return vals
+	}
+	maxes := make([]int64, (len(vals)+per-1)/per)
+	for i := range vals {
+		fromEnd := len(vals) - 1 - i
+		j := len(maxes) - 1 - fromEnd/per
+		if vals[i] > maxes[j] {
+			maxes[j] = vals[i]
+		}
+	}
+	return maxes
+}
@@ -0,0 +1,86 @@
+package ringbuffer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHistoryBasic(t *testing.T) {
+	h := MakeNewHistory(5)
+
+	if x := h.Len(); x != 0 {
+		t.Errorf("new history Len() s/b 0, got %v", x)
+	}
+	if x := h.Last(3); len(x) != 0 {
+		t.Errorf("new history Last(3) s/b empty, got %v", x)
+	}
+
+	h.Push(1)
+	h.Push(2)
+	h.Push(3)
+	if x := h.Last(10); !reflect.DeepEqual(x, []int64{1, 2, 3}) {
+		t.Errorf("Last(10) s/b [1 2 3], got %v", x)
+	}
+	if x := h.Last(2); !reflect.DeepEqual(x, []int64{2, 3}) {
+		t.Errorf("Last(2) s/b [2 3], got %v", x)
+	}
+}
+
+func TestHistoryWraps(t *testing.T) {
+	h := MakeNewHistory(5)
+	for i := int64(1); i <= 12; i++ {
+		h.Push(i)
+	}
+	if x := h.Len(); x != 5 {
+		t.Errorf("Len() s/b capped at 5, got %v", x)
+	}
+	if x := h.Last(5); !reflect.DeepEqual(x, []int64{8, 9, 10, 11, 12}) {
+		t.Errorf("Last(5) s/b [8 9 10 11 12], got %v", x)
+	}
+	if x := h.Last(1); !reflect.DeepEqual(x, []int64{12}) {
+		t.Errorf("Last(1) s/b [12], got %v", x)
+	}
+
+	h.Clear()
+	if x := h.Len(); x != 0 {
+		t.Errorf("Len() after Clear() s/b 0, got %v", x)
+	}
+}
+
+func TestHistoryRange(t *testing.T) {
+	h := MakeNewHistory(5)
+	for i := int64(1); i <= 7; i++ {
+		h.Push(i)
+	}
+	if x := h.Range(2, 2); !reflect.DeepEqual(x, []int64{4, 5}) {
+		t.Errorf("Range(2, 2) s/b [4 5], got %v", x)
+	}
+	// only 5 in there, so skipping 3 leaves 2
+	if x := h.Range(3, 10); !reflect.DeepEqual(x, []int64{3, 4}) {
+		t.Errorf("Range(3, 10) s/b [3 4], got %v", x)
+	}
+	if x := h.Range(9, 2); len(x) != 0 {
+		t.Errorf("Range(9, 2) s/b empty, got %v", x)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	vals := []int64{1, 2, 3, 4, 5, 6, 7}
+	if x := Aggregate(vals, 1); !reflect.DeepEqual(x, vals) {
+		t.Errorf("Aggregate by 1 s/b unchanged, got %v", x)
+	}
+	// the short group is the oldest one
+	if x := Aggregate(vals, 3); !reflect.DeepEqual(x, []int64{1, 9, 18}) {
+		t.Errorf("Aggregate by 3 s/b [1 9 18], got %v", x)
+	}
+	if x := Aggregate(nil, 10); len(x) != 0 {
+		t.Errorf("Aggregate of nothing s/b empty, got %v", x)
+	}
+}
+
+func TestAggregateMax(t *testing.T) {
+	vals := []int64{5, 2, 3, 9, 1, 6, 7}
+	if x := AggregateMax(vals, 3); !reflect.DeepEqual(x, []int64{5, 9, 7}) {
+		t.Errorf("AggregateMax by 3 s/b [5 9 7], got %v", x)
+	}
+}
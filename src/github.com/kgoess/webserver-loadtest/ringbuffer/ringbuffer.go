@@ -15,23 +15,40 @@ type Ringbuffer struct {
 
 func MakeNew(infolog *log.Logger) *Ringbuffer {
 	rb := new(Ringbuffer)
+	// otherwise the first second's worth of ChangeHeadBy lands in slot 0
+	rb.head = time.Now().Second()
 	INFO = infolog
 	go rb.advanceWithTimer()
 	return rb
 }
 
+// MakeNewAt is for an owner that moves the head itself with MoveHeadTo,
+// from the same goroutine that changes the values, so there's no timer
+// goroutine racing it
+func MakeNewAt(second int, infolog *log.Logger) *Ringbuffer {
+	rb := new(Ringbuffer)
+	INFO = infolog
+	rb.MoveHeadTo(second)
+	return rb
+}
+
 func (rb *Ringbuffer) advanceWithTimer() {
 	ticker := time.Tick(1 * time.Second)
 	for now := range ticker {
-		currentSecond := now.Second()
-		if currentSecond > 59 {
-			currentSecond = 0
-		}
-		rb.head = currentSecond
-		rb.ResetNextVal()
+		rb.MoveHeadTo(now.Second())
 	}
 }
 
+// MoveHeadTo makes second the current one, and zeroes the one after it
+// ready for when the clock gets there
+func (rb *Ringbuffer) MoveHeadTo(second int) {
+	if second > 59 {
+		second = 0
+	}
+	rb.head = second
+	rb.ResetNextVal()
+}
+
 func (rb *Ringbuffer) GetVal() int64 {
 	return rb.array[rb.head]
 }
@@ -102,6 +119,13 @@ func (rb *Ringbuffer) ResetNextVal() {
 	rb.array[i] = 0
 }
 
+// zero the whole thing, the head stays where the clock says
+func (rb *Ringbuffer) Clear() {
+	for i := range rb.array {
+		rb.array[i] = 0
+	}
+}
+
 func (rb *Ringbuffer) Length() int {
 	return len(rb.array)
 }
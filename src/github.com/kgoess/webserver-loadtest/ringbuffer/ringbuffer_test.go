@@ -159,3 +159,21 @@ output:
 http://stackoverflow.com/questions/8539551/dynamically-initialize-array-size-in-go
 
 */
+
+func TestRingbufferMoveHeadTo(t *testing.T) {
+	rb := MakeNewAt(58, nil)
+	rb.IncrementHead()
+	rb.IncrementAt(59) // a request that beat the head there
+	rb.MoveHeadTo(59)
+	if x := rb.GetPrevVal(); x != 1 {
+		t.Errorf("GetPrevVal() after MoveHeadTo(59) s/b 1, got %v", x)
+	}
+	if x := rb.GetVal(); x != 1 {
+		t.Errorf("MoveHeadTo shouldn't zero the new head, got %v", x)
+	}
+	rb.IncrementAtBy(0, 5) // from a minute ago
+	rb.MoveHeadTo(59)
+	if x := rb.GetValAt(0); x != 0 {
+		t.Errorf("MoveHeadTo(59) s/b zeroing slot 0 for the next second, got %v", x)
+	}
+}
@@ -0,0 +1,382 @@
+package session
+
+// Virtual users for --script. Instead of one GET over and over, each
+// worker walks through the steps in a script (log in, look around, add to
+// the cart, check out) like somebody using the site would. Every pass
+// through the script is a new user with its own cookie jar, and values
+// pulled out of one response (a CSRF token, a cart id) can be put into
+// the later requests as {{name}}. The urls, headers and bodies can have
+// any of the tmpl placeholders too, and the whole pass gets the same row
+// from each csv.
+//
+// The script is JSON:
+//
+//	{"steps": [
+//	  {"name": "login form", "url": "/login",
+//	   "extract": {"csrf": {"regex": "name=\"csrf\" value=\"([^\"]+)\""}}},
+//	  {"name": "log in", "method": "POST", "url": "/login",
+//	   "headers": {"Content-Type": "application/x-www-form-urlencoded"},
+//	   "body": "user=load{{worker}}&csrf={{csrf}}"},
+//	  {"name": "add to cart", "method": "POST", "url": "/api/cart", "expect": 201,
+//	   "extract": {"cart": {"json": "$.cart.id"}}},
+//	  {"name": "checkout", "method": "POST", "url": "/api/cart/{{cart}}/checkout"}
+//	]}
+//
+// Relative urls are taken from the --url. {{worker}} and {{iteration}} are
+// always there. A regex extract takes its first group (or the whole match
+// if it hasn't got one), a json one takes a path like $.items[0].id. If a
+// step doesn't get the status it expects (anything under 400 if it doesn't
+// say) or an extract doesn't find anything, the rest of that pass is
+// skipped, since it'd only fail too. Redirects are followed, unless the
+// step expects a 3xx, like "expect": 302 for a login form that redirects
+// when it works, then it gets the redirect itself.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	httpclient "github.com/kgoess/webserver-loadtest/httpclient"
+	tmpl "github.com/kgoess/webserver-loadtest/tmpl"
+)
+
+type Extract struct {
+	Regex string `json:"regex"`
+	JSON  string `json:"json"`
+
+	re   *regexp.Regexp
+	path []interface{} // string keys and int indexes
+}
+
+type Step struct {
+	Name    string              `json:"name"`
+	Method  string              `json:"method"`
+	URL     string              `json:"url"`
+	Headers map[string]string   `json:"headers"`
+	Body    string              `json:"body"`
+	Expect  int                 `json:"expect"`
+	Extract map[string]*Extract `json:"extract"`
+
+	url     *tmpl.Template
+	body    *tmpl.Template
+	headers map[string]*tmpl.Template
+}
+
+type Script struct {
+	Steps []*Step `json:"steps"`
+
+	// what the users' requests go through, nil for the default
+	Transport http.RoundTripper `json:"-"`
+
+	base *url.URL
+}
+
+// what happened on one step, for the stats
+type Result struct {
+	Step     string
+	Method   string
+	URL      string
+	Pattern  string         // the url as the script has it, for grouping the messages
+	ID       string         // what Tag gave it
+	Resp     *http.Response // nil if it didn't get that far, the body's been read and closed
+	Bytes    int64
+	Duration time.Duration // to the response headers, same as the plain requests
+	Err      error         // the request didn't get a response at all
+	Failed   string        // not what the step wanted, or the step itself was no good
+}
+
+func Load(path string, baseUrl string, templates *tmpl.Set) (*Script, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data, baseUrl, templates)
+}
+
+func Parse(data []byte, baseUrl string, templates *tmpl.Set) (*Script, error) {
+	base, err := url.Parse(baseUrl)
+	if err != nil {
+		return nil, fmt.Errorf("bad base url: %v", err)
+	}
+	s := &Script{base: base}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("can't parse script: %v", err)
+	}
+	if len(s.Steps) == 0 {
+		return nil, fmt.Errorf("script has no steps")
+	}
+	for n, step := range s.Steps {
+		if step.Name == "" {
+			step.Name = "step " + strconv.Itoa(n+1)
+		}
+		if step.URL == "" {
+			return nil, fmt.Errorf("%s has no url", step.Name)
+		}
+		if step.Method == "" {
+			step.Method = "GET"
+		}
+		step.Method = strings.ToUpper(step.Method)
+		if step.url, err = templates.Parse(step.URL); err != nil {
+			return nil, fmt.Errorf("%s: %v", step.Name, err)
+		}
+		if step.body, err = templates.Parse(step.Body); err != nil {
+			return nil, fmt.Errorf("%s: %v", step.Name, err)
+		}
+		step.headers = make(map[string]*tmpl.Template)
+		for k, v := range step.Headers {
+			if step.headers[k], err = templates.Parse(v); err != nil {
+				return nil, fmt.Errorf("%s: %v", step.Name, err)
+			}
+		}
+		for name, ex := range step.Extract {
+			if ex == nil || (ex.Regex == "") == (ex.JSON == "") {
+				return nil, fmt.Errorf("%s: extract %q s/b either a regex or a json path", step.Name, name)
+			}
+			if ex.Regex != "" {
+				if ex.re, err = regexp.Compile(ex.Regex); err != nil {
+					return nil, fmt.Errorf("%s: extract %q: %v", step.Name, name, err)
+				}
+			} else if ex.path, err = parsePath(ex.JSON); err != nil {
+				return nil, fmt.Errorf("%s: extract %q: %v", step.Name, name, err)
+			}
+		}
+	}
+	return s, nil
+}
+
+// one pass through the script, with its own cookies
+type User struct {
+	script *Script
+	client *http.Client
+	env    *tmpl.Env
+	follow bool // redirects, for the step that's going
+
+	// if set, called on each step's request just before it goes, e.g.
+	// to add a correlation id, which it returns. step counts from 1.
+	Tag func(req *http.Request, step int) string
+}
+
+// vars are any starting variables besides {{worker}}, e.g. iteration
+func (s *Script) NewUser(worker int, vars map[string]string) *User {
+	jar, _ := cookiejar.New(nil) // only errors on bad options
+	u := &User{
+		script: s,
+		env:    tmpl.NewEnv(worker, nil),
+	}
+	u.client = &http.Client{Jar: jar, Transport: s.Transport, CheckRedirect: u.checkRedirect}
+	for k, v := range vars {
+		u.env.Vars[k] = v
+	}
+	u.env.Vars["worker"] = strconv.Itoa(worker)
+	return u
+}
+
+// the cookies from the redirect are in the jar either way
+func (u *User) checkRedirect(req *http.Request, via []*http.Request) error {
+	if !u.follow {
+		return http.ErrUseLastResponse
+	}
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	return nil
+}
+
+func (u *User) Var(name string) string {
+	return u.env.Vars[name]
+}
+
+// Run does the steps in order, calling report after each one and pause
+// in between. Returns false if it stopped early, because a step failed or
+// ctx is done.
+func (u *User) Run(ctx context.Context, report func(Result), pause func()) bool {
+	for n, step := range u.script.Steps {
+		if n > 0 && pause != nil {
+			pause()
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		r := u.do(ctx, n+1, step)
+		if ctx.Err() != nil {
+			// we gave up on it, don't blame the server
+			return false
+		}
+		report(r)
+		if r.Err != nil || r.Failed != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func (u *User) do(ctx context.Context, n int, step *Step) Result {
+	r := Result{Step: step.Name, Method: step.Method, Pattern: step.URL}
+
+	rawUrl, err := step.url.Expand(u.env)
+	if err != nil {
+		r.Failed = err.Error()
+		return r
+	}
+	ref, err := url.Parse(rawUrl)
+	if err != nil {
+		r.Failed = err.Error()
+		return r
+	}
+	r.URL = u.script.base.ResolveReference(ref).String()
+
+	body, err := step.body.Expand(u.env)
+	if err != nil {
+		r.Failed = err.Error()
+		return r
+	}
+	req, err := http.NewRequest(step.Method, r.URL, strings.NewReader(body))
+	if err != nil {
+		r.Failed = err.Error()
+		return r
+	}
+	for k, t := range step.headers {
+		v, err := t.Expand(u.env)
+		if err != nil {
+			r.Failed = err.Error()
+			return r
+		}
+		req.Header.Set(k, v)
+	}
+	if u.Tag != nil {
+		r.ID = u.Tag(req, n)
+	}
+
+	u.follow = step.Expect < 300 || step.Expect >= 400
+	// leaving out any time the --proxy took to set up a connection, like
+	// the plain requests do
+	ctx, timing := httpclient.WithTiming(ctx)
+	t0 := time.Now()
+	resp, err := u.client.Do(req.WithContext(ctx))
+	r.Duration = time.Since(t0) - timing.ProxyConnect()
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	r.Resp = resp
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	r.Bytes = int64(len(respBody))
+	if err != nil {
+		r.Failed = "reading the response: " + err.Error()
+		return r
+	}
+
+	if step.Expect != 0 && resp.StatusCode != step.Expect {
+		r.Failed = fmt.Sprintf("%s, s/b %d", resp.Status, step.Expect)
+		return r
+	}
+	if step.Expect == 0 && resp.StatusCode >= 400 {
+		r.Failed = resp.Status
+		return r
+	}
+
+	for name, ex := range step.Extract {
+		val, ok := ex.find(respBody)
+		if !ok {
+			r.Failed = "nothing to extract for " + name
+			return r
+		}
+		u.env.Vars[name] = val
+	}
+	return r
+}
+
+func (ex *Extract) find(body []byte) (string, bool) {
+	if ex.re != nil {
+		m := ex.re.FindSubmatch(body)
+		if m == nil {
+			return "", false
+		}
+		if len(m) > 1 {
+			return string(m[1]), true
+		}
+		return string(m[0]), true
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return "", false
+	}
+	for _, p := range ex.path {
+		switch key := p.(type) {
+		case string:
+			obj, ok := doc.(map[string]interface{})
+			if !ok {
+				return "", false
+			}
+			if doc, ok = obj[key]; !ok {
+				return "", false
+			}
+		case int:
+			arr, ok := doc.([]interface{})
+			if !ok || key >= len(arr) {
+				return "", false
+			}
+			doc = arr[key]
+		}
+	}
+	switch v := doc.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	default:
+		out, _ := json.Marshal(v)
+		return string(out), true
+	}
+}
+
+// just enough JSONPath for pulling one value out: $.a.b[0].c
+func parsePath(path string) ([]interface{}, error) {
+	rest := strings.TrimPrefix(strings.TrimSpace(path), "$")
+	var parts []interface{}
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("bad json path %q", path)
+			}
+			parts = append(parts, rest[:end])
+			rest = rest[end:]
+		case '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("bad json path %q", path)
+			}
+			idx, err := strconv.Atoi(rest[1:end])
+			if err != nil || idx < 0 {
+				return nil, fmt.Errorf("bad index in json path %q", path)
+			}
+			parts = append(parts, idx)
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("json path %q s/b like $.a.b[0]", path)
+		}
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("empty json path %q", path)
+	}
+	return parts, nil
+}
@@ -0,0 +1,310 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	httpclient "github.com/kgoess/webserver-loadtest/httpclient"
+	tmpl "github.com/kgoess/webserver-loadtest/tmpl"
+)
+
+// a little shop: you need the login form's token and its cookie to log
+// in, and the cart id from the json to check out
+func shop(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			http.SetCookie(w, &http.Cookie{Name: "sid", Value: "s1"})
+			fmt.Fprint(w, `<form><input name="csrf" value="tok123"></form>`)
+			return
+		}
+		r.ParseForm()
+		if c, err := r.Cookie("sid"); err != nil || c.Value != "s1" || r.Form.Get("csrf") != "tok123" {
+			http.Error(w, "nope", http.StatusForbidden)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "user", Value: r.Form.Get("user")})
+		fmt.Fprint(w, "welcome")
+	})
+	mux.HandleFunc("/api/cart", func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie("user")
+		if err != nil {
+			http.Error(w, "log in first", http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"cart": {"id": "cart-%s", "items": [{"sku": 42}]}}`, c.Value)
+	})
+	mux.HandleFunc("/api/cart/cart-load7/checkout", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Sku") != "42" {
+			http.Error(w, "wrong sku", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, "thanks")
+	})
+	return httptest.NewServer(mux)
+}
+
+const shopScript = `{"steps": [
+  {"name": "login form", "url": "/login",
+   "extract": {"csrf": {"regex": "name=\"csrf\" value=\"([^\"]+)\""}}},
+  {"name": "log in", "method": "post", "url": "/login",
+   "headers": {"Content-Type": "application/x-www-form-urlencoded"},
+   "body": "user=load{{worker}}&csrf={{ csrf }}"},
+  {"name": "add to cart", "method": "POST", "url": "/api/cart", "expect": 201,
+   "extract": {"cart": {"json": "$.cart.id"}, "sku": {"json": "$.cart.items[0].sku"}}},
+  {"url": "/api/cart/{{cart}}/checkout", "headers": {"X-Sku": "{{sku}}"}}
+]}`
+
+func TestFlow(t *testing.T) {
+	srv := shop(t)
+	defer srv.Close()
+
+	script, err := Parse([]byte(shopScript), srv.URL+"/", tmpl.NewSet())
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	var results []Result
+	pauses := 0
+	u := script.NewUser(7, nil)
+	u.Tag = func(req *http.Request, step int) string { return fmt.Sprintf("t-%d", step) }
+	ok := u.Run(context.Background(), func(r Result) { results = append(results, r) }, func() { pauses++ })
+	if !ok {
+		t.Errorf("Run s/b ok, results %+v", results)
+	}
+	if len(results) != 4 {
+		t.Fatalf("s/b 4 results, got %d", len(results))
+	}
+	for _, r := range results {
+		if r.Err != nil || r.Failed != "" || r.Resp == nil {
+			t.Errorf("%s s/b ok, got err %v, failed %q", r.Step, r.Err, r.Failed)
+		}
+	}
+	if results[1].Method != "POST" {
+		t.Errorf("method s/b upcased, got %q", results[1].Method)
+	}
+	if results[3].Step != "step 4" {
+		t.Errorf("unnamed step s/b called by its number, got %q", results[3].Step)
+	}
+	if want := srv.URL + "/api/cart/cart-load7/checkout"; results[3].URL != want {
+		t.Errorf("url s/b %s, got %s", want, results[3].URL)
+	}
+	if results[3].Bytes != int64(len("thanks")) {
+		t.Errorf("bytes s/b %d, got %d", len("thanks"), results[3].Bytes)
+	}
+	if results[3].ID != "t-4" {
+		t.Errorf("Tag s/b called with the step number, got %q", results[3].ID)
+	}
+	if pauses != 3 {
+		t.Errorf("s/b a pause between each step, got %d", pauses)
+	}
+	if u.Var("csrf") != "tok123" || u.Var("cart") != "cart-load7" || u.Var("sku") != "42" {
+		t.Errorf("extracted vars s/b tok123, cart-load7, 42, got %q %q %q", u.Var("csrf"), u.Var("cart"), u.Var("sku"))
+	}
+
+	// a new user doesn't get the old one's cookies
+	fresh := script.NewUser(8, nil)
+	if cookies := fresh.client.Jar.Cookies(results[0].Resp.Request.URL); len(cookies) != 0 {
+		t.Errorf("new user s/b no cookies, got %v", cookies)
+	}
+}
+
+func TestStopsOnFailure(t *testing.T) {
+	srv := shop(t)
+	defer srv.Close()
+
+	// skipping the login form means no cookie and no token
+	script, err := Parse([]byte(`{"steps": [
+	  {"name": "log in", "method": "POST", "url": "/login", "body": "user=x"},
+	  {"name": "add to cart", "method": "POST", "url": "/api/cart"}
+	]}`), srv.URL, tmpl.NewSet())
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	var results []Result
+	if script.NewUser(0, nil).Run(context.Background(), func(r Result) { results = append(results, r) }, nil) {
+		t.Errorf("Run s/b false after a failed step")
+	}
+	if len(results) != 1 || results[0].Resp == nil || results[0].Resp.StatusCode != 403 {
+		t.Fatalf("s/b stopped after one 403, got %+v", results)
+	}
+	if !strings.HasPrefix(results[0].Failed, "403") {
+		t.Errorf("Failed s/b the status, got %q", results[0].Failed)
+	}
+
+	// an unset variable fails the step without making the request
+	script, _ = Parse([]byte(`{"steps": [{"url": "/api/cart/{{cart}}/checkout"}]}`), srv.URL, tmpl.NewSet())
+	results = nil
+	script.NewUser(0, nil).Run(context.Background(), func(r Result) { results = append(results, r) }, nil)
+	if len(results) != 1 || results[0].Resp != nil || !strings.Contains(results[0].Failed, "{{cart}}") {
+		t.Errorf("s/b a failure about {{cart}}, got %+v", results)
+	}
+
+	// expecting the wrong status is a failure even if it's a 200
+	script, _ = Parse([]byte(`{"steps": [{"url": "/login", "expect": 204}]}`), srv.URL, tmpl.NewSet())
+	results = nil
+	script.NewUser(0, nil).Run(context.Background(), func(r Result) { results = append(results, r) }, nil)
+	if len(results) != 1 || results[0].Failed != "200 OK, s/b 204" {
+		t.Errorf("s/b a failure about the status, got %+v", results)
+	}
+}
+
+// a login form that redirects when it works, like most of them
+func TestRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: "s2"})
+		http.Redirect(w, r, "/home", http.StatusFound)
+	})
+	mux.HandleFunc("/home", func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("sid"); err != nil || c.Value != "s2" {
+			http.Error(w, "log in first", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, "home")
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	script, err := Parse([]byte(`{"steps": [
+	  {"name": "log in", "method": "POST", "url": "/login", "expect": 302},
+	  {"name": "home", "url": "/home", "expect": 200}
+	]}`), srv.URL, tmpl.NewSet())
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	var results []Result
+	if !script.NewUser(0, nil).Run(context.Background(), func(r Result) { results = append(results, r) }, nil) {
+		t.Errorf("Run s/b ok, got %+v", results)
+	}
+	if len(results) != 2 || results[0].Resp.StatusCode != 302 || results[1].Resp.StatusCode != 200 {
+		t.Fatalf("s/b the 302 and then home with its cookie, got %+v", results)
+	}
+
+	// not expecting a 3xx, it follows it
+	script, _ = Parse([]byte(`{"steps": [{"method": "POST", "url": "/login"}]}`), srv.URL, tmpl.NewSet())
+	results = nil
+	script.NewUser(0, nil).Run(context.Background(), func(r Result) { results = append(results, r) }, nil)
+	if len(results) != 1 || results[0].Resp.StatusCode != 200 || results[0].Resp.Request.URL.Path != "/home" {
+		t.Errorf("s/b followed to /home, got %+v", results)
+	}
+}
+
+// a socks5 proxy that takes its time setting up each tunnel
+func slowProxy(t *testing.T, delay time.Duration) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 10)
+				io.ReadFull(conn, buf[:3])
+				time.Sleep(delay)
+				conn.Write([]byte{5, 0})
+				// connect to an ipv4 address, which is all the test needs
+				io.ReadFull(conn, buf[:10])
+				target, err := net.Dial("tcp", net.JoinHostPort(net.IP(buf[4:8]).String(), strconv.Itoa(int(buf[8])<<8|int(buf[9]))))
+				if err != nil {
+					conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer target.Close()
+				conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				go io.Copy(target, conn)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+	return l
+}
+
+func TestProxyTimeLeftOut(t *testing.T) {
+	srv := shop(t)
+	defer srv.Close()
+	l := slowProxy(t, 200*time.Millisecond)
+	defer l.Close()
+	client, err := httpclient.MakeNew(httpclient.Config{Proxy: "socks5://" + l.Addr().String()}, log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("httpclient.MakeNew failed: %v", err)
+	}
+
+	script, err := Parse([]byte(`{"steps": [{"url": "/login"}]}`), srv.URL, tmpl.NewSet())
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	script.Transport = client.Transport
+	var results []Result
+	t0 := time.Now()
+	if !script.NewUser(0, nil).Run(context.Background(), func(r Result) { results = append(results, r) }, nil) {
+		t.Fatalf("Run through the proxy s/b ok, got %+v", results)
+	}
+	if took := time.Since(t0); took < 200*time.Millisecond {
+		t.Fatalf("the proxy s/b slow, the whole thing took %v", took)
+	}
+	if len(results) != 1 || results[0].Duration >= 200*time.Millisecond {
+		t.Errorf("the step's time s/b without the proxy's, got %+v", results)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, bad := range []string{
+		`not json`,
+		`{"steps": []}`,
+		`{"steps": [{"name": "x"}]}`,
+		`{"steps": [{"url": "/", "extract": {"a": {}}}]}`,
+		`{"steps": [{"url": "/", "extract": {"a": {"regex": "x", "json": "$.a"}}}]}`,
+		`{"steps": [{"url": "/", "extract": {"a": {"regex": "(unclosed"}}}]}`,
+		`{"steps": [{"url": "/", "extract": {"a": {"json": "$.a[x]"}}}]}`,
+		`{"steps": [{"url": "/", "extract": {"a": {"json": "$"}}}]}`,
+		`{"steps": [{"url": "/item/{{rand_int 1}}"}]}`,
+	} {
+		if _, err := Parse([]byte(bad), "http://example.com/", tmpl.NewSet()); err == nil {
+			t.Errorf("Parse(%s) s/b an error", bad)
+		}
+	}
+}
+
+func TestJSONExtract(t *testing.T) {
+	body := []byte(`{"a": {"b": [10, {"c": "deep"}], "t": true, "n": null}}`)
+	for _, tc := range []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{"$.a.b[0]", "10", true},
+		{"$.a.b[1].c", "deep", true},
+		{".a.t", "true", true},
+		{"$.a.b[2]", "", false},
+		{"$.a.n", "", false},
+		{"$.a.missing", "", false},
+		{"$.a.b.c", "", false},
+	} {
+		path, err := parsePath(tc.path)
+		if err != nil {
+			t.Errorf("parsePath(%q) failed: %v", tc.path, err)
+			continue
+		}
+		got, ok := (&Extract{path: path}).find(body)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("%s s/b %q %v, got %q %v", tc.path, tc.want, tc.ok, got, ok)
+		}
+	}
+}
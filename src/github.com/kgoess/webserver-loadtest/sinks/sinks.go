@@ -0,0 +1,243 @@
+package sinks
+
+// Pushes the per-second numbers out to a TSDB. A sink is a formatter
+// (statsd, graphite or influx line protocol) plus a udp or tcp connection.
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Stat struct {
+	Name  string
+	Value float64
+}
+
+type Sink interface {
+	Send(t time.Time, stats []Stat) error
+	Close() error
+}
+
+// turns one second's worth of stats into lines for the wire, without
+// the trailing newlines
+type Formatter interface {
+	Lines(t time.Time, stats []Stat) []string
+}
+
+// statsd gauges, e.g. "loadtest.box1.reqs:12|g"
+type StatsdFormat struct {
+	Prefix string
+}
+
+func (f StatsdFormat) Lines(t time.Time, stats []Stat) []string {
+	lines := make([]string, 0, len(stats))
+	for _, s := range stats {
+		lines = append(lines, fmt.Sprintf("%s:%s|g", dotted(f.Prefix, s.Name), formatValue(s.Value)))
+	}
+	return lines
+}
+
+// graphite plaintext, e.g. "loadtest.box1.reqs 12 1414000000"
+type GraphiteFormat struct {
+	Prefix string
+}
+
+func (f GraphiteFormat) Lines(t time.Time, stats []Stat) []string {
+	lines := make([]string, 0, len(stats))
+	for _, s := range stats {
+		lines = append(lines, fmt.Sprintf("%s %s %d", dotted(f.Prefix, s.Name), formatValue(s.Value), t.Unix()))
+	}
+	return lines
+}
+
+// influx line protocol, all the stats go in one line as fields, e.g.
+// "loadtest,node=box1 reqs=12,fails=0 1414000000000000000"
+type InfluxFormat struct {
+	Measurement string
+	Tags        map[string]string
+}
+
+func (f InfluxFormat) Lines(t time.Time, stats []Stat) []string {
+	if len(stats) == 0 {
+		return nil
+	}
+	line := influxEscape(f.Measurement, ", ")
+
+	tagNames := make([]string, 0, len(f.Tags))
+	for name := range f.Tags {
+		tagNames = append(tagNames, name)
+	}
+	sort.Strings(tagNames) // influx likes them sorted
+	for _, name := range tagNames {
+		if f.Tags[name] == "" {
+			continue
+		}
+		line += "," + influxEscape(name, ",= ") + "=" + influxEscape(f.Tags[name], ",= ")
+	}
+
+	fields := make([]string, 0, len(stats))
+	for _, s := range stats {
+		fields = append(fields, influxEscape(s.Name, ",= ")+"="+formatValue(s.Value))
+	}
+	line += " " + strings.Join(fields, ",") + " " + strconv.FormatInt(t.UnixNano(), 10)
+	return []string{line}
+}
+
+func influxEscape(s string, specials string) string {
+	for _, c := range specials {
+		s = strings.Replace(s, string(c), `\`+string(c), -1)
+	}
+	return s
+}
+
+var unsafePathChars = regexp.MustCompile(`[^A-Za-z0-9_\-]`)
+
+// PathSafe makes s usable as one component of a dotted graphite/statsd
+// name--hostnames are full of dots
+func PathSafe(s string) string {
+	return unsafePathChars.ReplaceAllString(s, "_")
+}
+
+func dotted(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// keep udp packets under a typical MTU so they don't get fragmented
+const maxPacketSize = 1400
+
+type lineSink struct {
+	network string
+	addr    string
+	format  Formatter
+	conn    net.Conn
+}
+
+// Dial takes a spec like "statsd+udp://host:8125", "graphite+tcp://host:2003"
+// or "influx+udp://host:8089". The transport defaults to udp for statsd
+// and influx and tcp for graphite. prefix is the dotted metric prefix for
+// statsd/graphite and the measurement name for influx, tags only apply
+// to influx.
+func Dial(spec string, prefix string, tags map[string]string) (Sink, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, errors.New("sink '" + spec + "' needs a host:port")
+	}
+
+	protocol, network := u.Scheme, ""
+	if i := strings.Index(protocol, "+"); i >= 0 {
+		protocol, network = protocol[:i], protocol[i+1:]
+	}
+
+	var format Formatter
+	switch protocol {
+	case "statsd":
+		format = StatsdFormat{prefix}
+		if network == "" {
+			network = "udp"
+		}
+	case "graphite":
+		format = GraphiteFormat{prefix}
+		if network == "" {
+			network = "tcp"
+		}
+	case "influx":
+		format = InfluxFormat{prefix, tags}
+		if network == "" {
+			network = "udp"
+		}
+	default:
+		return nil, errors.New("unknown sink type '" + protocol + "', want statsd, graphite or influx")
+	}
+	if network != "udp" && network != "tcp" {
+		return nil, errors.New("sink transport s/b udp or tcp, got '" + network + "'")
+	}
+
+	return NewLineSink(network, u.Host, format)
+}
+
+// NewLineSink is for when you've got your own Formatter
+func NewLineSink(network string, addr string, format Formatter) (Sink, error) {
+	s := &lineSink{network: network, addr: addr, format: format}
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *lineSink) connect() error {
+	conn, err := net.DialTimeout(s.network, s.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+func (s *lineSink) Send(t time.Time, stats []Stat) error {
+	// a tcp server going away shouldn't kill the sink for good, we'll
+	// try to reconnect next second
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if s.network == "udp" {
+		err = s.sendPackets(s.format.Lines(t, stats))
+	} else {
+		_, err = fmt.Fprint(s.conn, strings.Join(s.format.Lines(t, stats), "\n")+"\n")
+	}
+	if err != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+// batches lines into as few packets as we can, one line per packet at
+// worst
+func (s *lineSink) sendPackets(lines []string) error {
+	packet := ""
+	for _, line := range lines {
+		if packet != "" && len(packet)+1+len(line) > maxPacketSize {
+			if _, err := s.conn.Write([]byte(packet)); err != nil {
+				return err
+			}
+			packet = ""
+		}
+		if packet != "" {
+			packet += "\n"
+		}
+		packet += line
+	}
+	if packet != "" {
+		_, err := s.conn.Write([]byte(packet))
+		return err
+	}
+	return nil
+}
+
+func (s *lineSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
@@ -0,0 +1,158 @@
+package sinks
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testTime = time.Unix(1414000000, 0)
+
+var testStats = []Stat{
+	{"reqs", 12},
+	{"latency_ms", 3.25},
+}
+
+func TestFormats(t *testing.T) {
+	statsd := StatsdFormat{"loadtest.box1"}.Lines(testTime, testStats)
+	if len(statsd) != 2 || statsd[0] != "loadtest.box1.reqs:12|g" || statsd[1] != "loadtest.box1.latency_ms:3.25|g" {
+		t.Errorf("statsd lines wrong: %q", statsd)
+	}
+
+	graphite := GraphiteFormat{"loadtest"}.Lines(testTime, testStats)
+	if len(graphite) != 2 || graphite[0] != "loadtest.reqs 12 1414000000" {
+		t.Errorf("graphite lines wrong: %q", graphite)
+	}
+
+	influx := InfluxFormat{"loadtest", map[string]string{"url": "http://x/a b", "node": "box1"}}.Lines(testTime, testStats)
+	want := `loadtest,node=box1,url=http://x/a\ b reqs=12,latency_ms=3.25 1414000000000000000`
+	if len(influx) != 1 || influx[0] != want {
+		t.Errorf("influx line s/b\n%s\ngot\n%q", want, influx)
+	}
+}
+
+func TestPathSafe(t *testing.T) {
+	if got := PathSafe("box1.example.com"); got != "box1_example_com" {
+		t.Errorf("PathSafe s/b box1_example_com, got %s", got)
+	}
+}
+
+func TestUdpSink(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can't listen on udp: %v", err)
+	}
+	defer pc.Close()
+
+	sink, err := Dial("statsd://"+pc.LocalAddr().String(), "lt", nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer sink.Close()
+
+	if err := sink.Send(testTime, testStats); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	buf := make([]byte, 2048)
+	pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("reading packet failed: %v", err)
+	}
+	if got := string(buf[:n]); got != "lt.reqs:12|g\nlt.latency_ms:3.25|g" {
+		t.Errorf("packet s/b both lines, got %q", got)
+	}
+}
+
+func TestTcpSink(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can't listen on tcp: %v", err)
+	}
+	defer ln.Close()
+
+	linesCh := make(chan string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			linesCh <- scanner.Text()
+		}
+	}()
+
+	sink, err := Dial("graphite://"+ln.Addr().String(), "lt", nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer sink.Close()
+
+	if err := sink.Send(testTime, testStats); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	for _, want := range []string{"lt.reqs 12 1414000000", "lt.latency_ms 3.25 1414000000"} {
+		select {
+		case got := <-linesCh:
+			if got != want {
+				t.Errorf("line s/b %q, got %q", want, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+}
+
+func TestUdpBatching(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can't listen on udp: %v", err)
+	}
+	defer pc.Close()
+
+	sink, err := Dial("statsd+udp://"+pc.LocalAddr().String(), strings.Repeat("x", 200), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer sink.Close()
+
+	// 20 lines of ~210 bytes won't fit in one packet
+	stats := make([]Stat, 20)
+	for i := range stats {
+		stats[i] = Stat{"reqs", float64(i)}
+	}
+	if err := sink.Send(testTime, stats); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	lines := 0
+	buf := make([]byte, 4096)
+	for lines < len(stats) {
+		pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("reading packet failed after %d lines: %v", lines, err)
+		}
+		if n > maxPacketSize {
+			t.Errorf("packet of %d bytes is over the limit", n)
+		}
+		lines += strings.Count(string(buf[:n]), "\n") + 1
+	}
+	if lines != len(stats) {
+		t.Errorf("s/b %d lines, got %d", len(stats), lines)
+	}
+}
+
+func TestDialErrors(t *testing.T) {
+	for _, spec := range []string{"nonsense://localhost:1", "statsd+carrierpigeon://localhost:1", "statsd://"} {
+		if _, err := Dial(spec, "", nil); err == nil {
+			t.Errorf("Dial(%q) s/b an error", spec)
+		}
+	}
+}
@@ -119,7 +119,7 @@ INFO.Println("we read something from master! ", n, err)
 		delta := string(buf[:n])
 		requesterDelta, err := strconv.ParseInt(delta, 10, 0)
 		if err != nil {
-			INFO.Println("got a wonky message from the network: %s (%s)", delta, requesterDelta)
+			INFO.Printf("got a wonky message from the network: %s (%v)", delta, err)
 			break
 		}
 		go handleStatsToMaster(c, reqMadeOnSecSlaveListenerCh, joinBcastCb)
@@ -170,7 +170,7 @@ func sendStatsToMaster(c net.Conn, second int){
 	if writeErr != nil {
 		c.Close()
 		// actually, this might mean that the server has shut down, don't need
-		INFO.Println("writing json to connection failed: %v", writeErr)
+		INFO.Printf("writing json to connection failed: %v", writeErr)
 		panic(fmt.Sprintf("writing json to connection failed: %v", writeErr))
 	}
 }
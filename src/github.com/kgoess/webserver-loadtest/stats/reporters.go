@@ -0,0 +1,42 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// TextReporter writes one compact line per second, good for tail -f,
+// tee and dumb terminals
+type TextReporter struct {
+	w io.Writer
+}
+
+func NewTextReporter(w io.Writer) *TextReporter {
+	return &TextReporter{w}
+}
+
+func (r *TextReporter) Report(s Snapshot) {
+	faults := ""
+	if total := s.TotalFaults(); total > 0 {
+		faults = fmt.Sprintf(" faults=%d", total)
+	}
+	fmt.Fprintf(r.w, "%s workers=%d req/s=%d fails=%d avg/p99=%.2f/%.2fms bytes/s=%.2f%s\n",
+		s.Time.Format("15:04:05"), s.Workers, s.ReqSec, s.Fails,
+		s.LatencyMs, s.LatencyP99Ms, s.BytesPerSec, faults)
+}
+
+// JSONReporter writes one JSON object per line
+type JSONReporter struct {
+	enc *json.Encoder
+}
+
+func NewJSONReporter(w io.Writer) *JSONReporter {
+	return &JSONReporter{json.NewEncoder(w)}
+}
+
+func (r *JSONReporter) Report(s Snapshot) {
+	if err := r.enc.Encode(s); err != nil {
+		INFO.Printf("writing json snapshot failed: %v", err)
+	}
+}
@@ -0,0 +1,367 @@
+package stats
+
+// Collects what the requesters report and boils it down into one Snapshot
+// per second, which gets handed to whatever Reporters are listening (the
+// ncurses display, the web dashboard, a log file...). The reporters never
+// see the raw channels, so adding a new output doesn't mean touching the
+// curses code.
+
+import (
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	rb "github.com/kgoess/webserver-loadtest/ringbuffer"
+)
+
+// debugging kludge--is this really the way to share global loggers?
+var (
+	INFO *log.Logger
+)
+
+// what the requesters send on the fails channel
+type Fail struct {
+	Second    int
+	Class     string // "network", "timeout", "5xx"...
+	Responded bool   // there was a response, and it went in the requests too
+}
+
+// what the requesters send on the bytes channel
+type Bytes struct {
+	Bytes         int64
+	DurationMs    int64
+	ReceivedOnSec int
+}
+
+// Everything the displays show, as of the last tick
+type Snapshot struct {
+	Time            time.Time `json:"time"`
+	Workers         int       `json:"workers"`
+	InFlight        int64     `json:"inFlight"` // filled in by whoever has the worker pool
+	ReqSec          int64     `json:"reqSec"`   // requests in the last complete second
+	ReqSecAvg5      int64     `json:"reqSecAvg5"`
+	ReqSecAvg60     int64     `json:"reqSecAvg60"` // less than 60 until we've been up a minute
+	Fails           int64     `json:"fails"`       // fails in the last complete second
+	Unanswered      int64     `json:"-"`           // the Fails with no response, so not in ReqSec
+	LatencyMs       float64   `json:"latencyMs"`   // average over LatencyLookback seconds
+	LatencyLookback int       `json:"-"`
+	LatencyCount    int64     `json:"-"`            // how many requests went into LatencyMs
+	LatencyP50Ms    float64   `json:"latencyP50Ms"` // percentiles are over the last tick
+	LatencyP95Ms    float64   `json:"latencyP95Ms"`
+	LatencyP99Ms    float64   `json:"latencyP99Ms"`
+	BytesPerSec     float64   `json:"bytesPerSec"`
+	Max             int64     `json:"max"` // most requests in any second in Bars
+
+	// faults injected so far by kind, filled in by whoever has the
+	// injector
+	Faults map[string]int64 `json:"faults,omitempty"`
+
+	// so far, filled in by whoever has the http client, nil until there's
+	// been a TLS handshake
+	TLS *TLSStats `json:"tls,omitempty"`
+
+	// so far by the address they went to, only with --resolve
+	Backends map[string]*BackendStats `json:"backends,omitempty"`
+
+	// setting up tunnels so far, only with --proxy
+	Proxy *ProxyStats `json:"proxy,omitempty"`
+
+	// so far, only with --http2
+	H2 *H2Stats `json:"http2,omitempty"`
+
+	// so far, only for ws:// and wss:// urls
+	WS *WSStats `json:"websocket,omitempty"`
+
+	// one column per wall-clock second, so Bars[Time.Second()] is the
+	// second that's still in progress
+	Bars     []int64 `json:"-"`
+	FailBars []int64 `json:"-"`
+
+	// one value per completed second, for displays that scroll instead
+	// of wrapping every minute
+	ReqHistory  *rb.History `json:"-"`
+	FailHistory *rb.History `json:"-"`
+	P50History  *rb.History `json:"-"` // whole ms
+	P99History  *rb.History `json:"-"`
+}
+
+// what the TLS connections negotiated
+type TLSStats struct {
+	Handshakes     int64            `json:"handshakes"`
+	Failed         int64            `json:"failed"`
+	Resumed        int64            `json:"resumed"` // of the Handshakes
+	HandshakeAvgMs float64          `json:"handshakeAvgMs"`
+	HandshakeMaxMs float64          `json:"handshakeMaxMs"`
+	Versions       map[string]int64 `json:"versions"` // "TLS1.3": 12
+	Ciphers        map[string]int64 `json:"ciphers"`
+}
+
+// the requests that went to one address
+type BackendStats struct {
+	Requests int64   `json:"requests"`
+	Fails    int64   `json:"fails"` // errors and 4xx/5xx
+	AvgMs    float64 `json:"avgMs"` // to the response headers
+}
+
+// the --proxy's side of things, the time to set up each tunnel isn't in
+// the request times
+type ProxyStats struct {
+	Connects     int64   `json:"connects"`
+	Failed       int64   `json:"failed"`
+	ConnectAvgMs float64 `json:"connectAvgMs"`
+	ConnectMaxMs float64 `json:"connectMaxMs"`
+}
+
+// how the requests got multiplexed with --http2
+type H2Stats struct {
+	Connections    int64            `json:"connections"`
+	Reconnects     int64            `json:"reconnects"` // after a GOAWAY or the connection dropping
+	Streams        int64            `json:"streams"`
+	StreamsPerConn float64          `json:"streamsPerConn"`
+	PeakStreams    int64            `json:"peakStreams"` // the most at once on one connection
+	GoAways        int64            `json:"goAways"`     // only the ones with an error code
+	Resets         int64            `json:"resets"`      // RST_STREAMs from the server
+	Errors         map[string]int64 `json:"errors"`      // everything the http2 package counted
+}
+
+// the websocket connections, the message round trips are the request
+// times
+type WSStats struct {
+	Open         int64   `json:"open"` // right now
+	Connects     int64   `json:"connects"`
+	ConnectFails int64   `json:"connectFails"`
+	Disconnects  int64   `json:"disconnects"` // closed or dropped by the server
+	ConnectAvgMs float64 `json:"connectAvgMs"`
+	ConnectMaxMs float64 `json:"connectMaxMs"`
+	Sent         int64   `json:"sent"`
+	Received     int64   `json:"received"`
+}
+
+type Reporter interface {
+	Report(s Snapshot)
+}
+
+// all the kinds of fault added up
+func (s Snapshot) TotalFaults() int64 {
+	var total int64
+	for _, n := range s.Faults {
+		total += n
+	}
+	return total
+}
+
+// where the Collector gets its raw data, these are all bcast listener
+// channels
+type Inputs struct {
+	ReqMadeOnSec  <-chan interface{} // int second
+	Fails         <-chan interface{} // Fail
+	Durations     <-chan interface{} // int64 ms
+	Bytes         <-chan interface{} // Bytes
+	NumRequesters <-chan interface{} // int delta, +1 or -1
+	Reset         <-chan bool        // start the numbers over, e.g. after a config change
+}
+
+type Collector struct {
+	requestsForSecond   *rb.Ringbuffer // one column for each clock second
+	failsForSecond      *rb.Ringbuffer
+	unansweredForSecond *rb.Ringbuffer // the fails that aren't in requestsForSecond
+	totalDurForSecond   *rb.Ringbuffer // total durations for each clock second
+	countForSecond      *rb.Ringbuffer // how many durations received per second
+	bytesRecdForSecond  *rb.Ringbuffer
+	durationForSecond   *rb.Ringbuffer // durations of the requests in bytesRecdForSecond
+	durationsThisTick   []int64        // for the percentiles
+	reqHistory          *rb.History
+	failHistory         *rb.History
+	p50History          *rb.History
+	p99History          *rb.History
+	lookbackSecs        int
+	secsSeen            int
+	workers             int
+	ticks               <-chan time.Time // once a second, the ringbuffers move on then
+}
+
+// historySecs is how far back ReqHistory and FailHistory go
+func MakeNew(historySecs int, infoLog *log.Logger) *Collector {
+	return newCollector(historySecs, infoLog, time.Now(), time.Tick(1*time.Second))
+}
+
+// the tests bring their own clock
+func newCollector(historySecs int, infoLog *log.Logger, now time.Time, ticks <-chan time.Time) *Collector {
+	INFO = infoLog
+	second := now.Second()
+	return &Collector{
+		requestsForSecond:   rb.MakeNewAt(second, infoLog),
+		failsForSecond:      rb.MakeNewAt(second, infoLog),
+		unansweredForSecond: rb.MakeNewAt(second, infoLog),
+		totalDurForSecond:   rb.MakeNewAt(second, infoLog),
+		countForSecond:      rb.MakeNewAt(second, infoLog),
+		bytesRecdForSecond:  rb.MakeNewAt(second, infoLog),
+		durationForSecond:   rb.MakeNewAt(second, infoLog),
+		reqHistory:          rb.MakeNewHistory(historySecs),
+		failHistory:         rb.MakeNewHistory(historySecs),
+		p50History:          rb.MakeNewHistory(historySecs),
+		p99History:          rb.MakeNewHistory(historySecs),
+		lookbackSecs:        5,
+		ticks:               ticks,
+	}
+}
+
+func (c *Collector) ringbuffers() []*rb.Ringbuffer {
+	return []*rb.Ringbuffer{c.requestsForSecond, c.failsForSecond, c.unansweredForSecond, c.totalDurForSecond,
+		c.countForSecond, c.bytesRecdForSecond, c.durationForSecond}
+}
+
+// Run loops forever, sending a Snapshot on snapshotCh once a second
+func (c *Collector) Run(in Inputs, snapshotCh chan<- Snapshot) {
+	for {
+		select {
+		case msg := <-in.ReqMadeOnSec:
+			c.requestsForSecond.IncrementAt(msg.(int))
+		case msg := <-in.Fails:
+			fail := msg.(Fail)
+			c.failsForSecond.IncrementAt(fail.Second)
+			if !fail.Responded {
+				c.unansweredForSecond.IncrementAt(fail.Second)
+			}
+		case msg := <-in.Durations:
+			c.totalDurForSecond.ChangeHeadBy(msg.(int64))
+			c.countForSecond.IncrementHead()
+			c.durationsThisTick = append(c.durationsThisTick, msg.(int64))
+		case m := <-in.Bytes:
+			msg := m.(Bytes)
+			c.bytesRecdForSecond.IncrementAtBy(msg.ReceivedOnSec, msg.Bytes)
+			c.durationForSecond.IncrementAtBy(msg.ReceivedOnSec, msg.DurationMs)
+		case msg := <-in.NumRequesters:
+			c.workers += msg.(int)
+			// the requesterController ignores a decrease when there's
+			// nothing to shut down, so we do too
+			if c.workers < 0 {
+				c.workers = 0
+			}
+		case <-in.Reset:
+			c.reset()
+		case now := <-c.ticks:
+			// only ever moved from here, so nothing's changing them
+			// underneath us
+			for _, r := range c.ringbuffers() {
+				r.MoveHeadTo(now.Second())
+			}
+			if c.secsSeen < 60 {
+				c.secsSeen++
+			}
+			c.reqHistory.Push(c.requestsForSecond.GetPrevVal())
+			c.failHistory.Push(c.failsForSecond.GetPrevVal())
+			s := c.snapshot(now)
+			c.p50History.Push(int64(s.LatencyP50Ms))
+			c.p99History.Push(int64(s.LatencyP99Ms))
+			c.durationsThisTick = c.durationsThisTick[:0]
+			snapshotCh <- s
+		}
+	}
+}
+
+// everything but the worker count, that's still true
+func (c *Collector) reset() {
+	INFO.Println("resetting stats")
+	for _, r := range c.ringbuffers() {
+		r.Clear()
+	}
+	for _, h := range []*rb.History{c.reqHistory, c.failHistory, c.p50History, c.p99History} {
+		h.Clear()
+	}
+	c.durationsThisTick = c.durationsThisTick[:0]
+	c.secsSeen = 0
+}
+
+func (c *Collector) snapshot(now time.Time) Snapshot {
+	secsSeen := c.secsSeen
+	if secsSeen == 0 {
+		secsSeen = 1
+	}
+	s := Snapshot{
+		Time:            now,
+		Workers:         c.workers,
+		ReqSec:          c.requestsForSecond.GetPrevVal(),
+		ReqSecAvg5:      c.requestsForSecond.SumPrevN(5) / 5, // won't be accurate for first five secs
+		ReqSecAvg60:     c.requestsForSecond.SumPrevN(secsSeen) / int64(secsSeen),
+		Fails:           c.failsForSecond.GetPrevVal(),
+		Unanswered:      c.unansweredForSecond.GetPrevVal(),
+		LatencyLookback: c.lookbackSecs,
+		Max:             c.requestsForSecond.GetMax(),
+		Bars:            copyOf(c.requestsForSecond.GetArray()),
+		FailBars:        copyOf(c.failsForSecond.GetArray()),
+		ReqHistory:      c.reqHistory,
+		FailHistory:     c.failHistory,
+		P50History:      c.p50History,
+		P99History:      c.p99History,
+	}
+
+	windowDur := c.totalDurForSecond.SumPrevN(c.lookbackSecs)
+	s.LatencyCount = c.countForSecond.SumPrevN(c.lookbackSecs)
+	if s.LatencyCount > 0 {
+		s.LatencyMs = float64(windowDur) / float64(s.LatencyCount)
+	}
+
+	if len(c.durationsThisTick) > 0 {
+		sorted := make([]int64, len(c.durationsThisTick))
+		copy(sorted, c.durationsThisTick)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		s.LatencyP50Ms = Percentile(sorted, 50)
+		s.LatencyP95Ms = Percentile(sorted, 95)
+		s.LatencyP99Ms = Percentile(sorted, 99)
+	}
+
+	bytesDur := c.durationForSecond.SumPrevN(c.lookbackSecs)
+	// divide-by-zero guard
+	if bytesDur == 0 {
+		bytesDur = 1
+	}
+	s.BytesPerSec = float64(c.bytesRecdForSecond.SumPrevN(c.lookbackSecs)) / float64(bytesDur) * 1000
+
+	return s
+}
+
+// ErrorPct is the fails as a percentage of everything that finished:
+// the requests that got a response (the non-200 ones are in both reqs
+// and fails) plus the fails that never got one. 0 if nothing finished.
+func ErrorPct(reqs int64, fails int64, unanswered int64) float64 {
+	if reqs+unanswered == 0 {
+		return 0
+	}
+	return float64(fails) / float64(reqs+unanswered) * 100
+}
+
+// Percentile uses the nearest-rank method, sorted has to be sorted
+func Percentile(sorted []int64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	} else if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return float64(sorted[rank])
+}
+
+// the reporters run in other goroutines, they shouldn't be looking at
+// the ringbuffers' own arrays
+func copyOf(a []int64) []int64 {
+	c := make([]int64, len(a))
+	copy(c, a)
+	return c
+}
+
+// the nominal bar height for displays that don't have a real one
+const DefaultBarRows = 25
+
+// Scale is how many requests each row of a bar chart stands for, if the
+// busiest column has to fit in rows
+func Scale(max int64, rows int) int64 {
+	if max == 0 || rows <= 0 {
+		return 1
+	}
+	return int64(max/int64(rows)) + 1
+}
@@ -0,0 +1,195 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScale(t *testing.T) {
+	for _, tc := range []struct {
+		max  int64
+		rows int
+		want int64
+	}{
+		{0, 25, 1},
+		{24, 25, 1},
+		{25, 25, 2},
+		{100, 25, 5},
+		{100, 0, 1},
+	} {
+		if got := Scale(tc.max, tc.rows); got != tc.want {
+			t.Errorf("Scale(%d, %d) s/b %d, got %d", tc.max, tc.rows, tc.want, got)
+		}
+	}
+}
+
+func TestCollectorSnapshot(t *testing.T) {
+	start := time.Date(2014, 10, 22, 13, 14, 10, 0, time.Local)
+	ticks := make(chan time.Time)
+	c := newCollector(60, log.New(ioutil.Discard, "", 0), start, ticks)
+
+	reqCh := make(chan interface{})
+	failsCh := make(chan interface{})
+	durCh := make(chan interface{})
+	bytesCh := make(chan interface{})
+	workersCh := make(chan interface{})
+	resetCh := make(chan bool)
+	snapshotCh := make(chan Snapshot)
+
+	go c.Run(Inputs{reqCh, failsCh, durCh, bytesCh, workersCh, resetCh}, snapshotCh)
+
+	workersCh <- 1
+	workersCh <- 1
+	workersCh <- -1
+	workersCh <- -1
+	workersCh <- -1 // one more than there are, s/b ignored
+	workersCh <- 1
+
+	now := start.Second()
+	for i := 0; i < 3; i++ {
+		reqCh <- now
+	}
+	failsCh <- Fail{Second: now, Class: "5xx", Responded: true}
+	failsCh <- Fail{Second: now, Class: "network"}
+	durCh <- int64(10)
+	durCh <- int64(20)
+
+	ticks <- start.Add(time.Second)
+	s := <-snapshotCh
+	if s.Workers != 1 {
+		t.Errorf("workers s/b 1, got %d", s.Workers)
+	}
+	if s.ReqSec != 3 || s.Fails != 2 || s.Unanswered != 1 {
+		t.Errorf("the second that just finished s/b 3 requests, 2 fails, 1 without a response, got %d, %d, %d",
+			s.ReqSec, s.Fails, s.Unanswered)
+	}
+	if s.Bars[now] != 3 {
+		t.Errorf("s/b 3 requests in second %d, got %v", now, s.Bars)
+	}
+	if s.Max != 3 {
+		t.Errorf("max s/b 3, got %d", s.Max)
+	}
+	if len(s.FailBars) != 60 {
+		t.Errorf("fail bars s/b 60 wide, got %d", len(s.FailBars))
+	}
+	if s.ReqHistory.Len() != 1 || s.FailHistory.Len() != 1 {
+		t.Errorf("s/b one second of history after one tick, got %d/%d", s.ReqHistory.Len(), s.FailHistory.Len())
+	}
+	if p := s.P99History.Last(1); len(p) != 1 || p[0] != 20 {
+		t.Errorf("p99 history s/b [20] after one tick, got %v", p)
+	}
+
+	// the durations went in at the head, so they're in the average now
+	// that second is over
+	if s.LatencyCount != 2 || s.LatencyMs != 15 {
+		t.Errorf("latency s/b 2 requests averaging 15ms, got %d averaging %.2f", s.LatencyCount, s.LatencyMs)
+	}
+
+	// a quiet second, the requests are still in the bars but not ReqSec
+	ticks <- start.Add(2 * time.Second)
+	s = <-snapshotCh
+	if s.ReqSec != 0 || s.Bars[now] != 3 || s.ReqHistory.Len() != 2 {
+		t.Errorf("s/b 0 req/s with the 3 still in the bars and 2 secs of history, got %d, %v, %d",
+			s.ReqSec, s.Bars, s.ReqHistory.Len())
+	}
+
+	resetCh <- true
+	ticks <- start.Add(3 * time.Second)
+	s = <-snapshotCh
+	if s.LatencyCount != 0 || s.Max != 0 || s.ReqHistory.Len() != 1 {
+		t.Errorf("after a reset s/b starting over, got latency count %d, max %d, %d secs of history",
+			s.LatencyCount, s.Max, s.ReqHistory.Len())
+	}
+	if s.Workers != 1 {
+		t.Errorf("reset shouldn't touch the workers, got %d", s.Workers)
+	}
+}
+
+func TestErrorPct(t *testing.T) {
+	for _, tc := range []struct {
+		reqs, fails, unanswered int64
+		want                    float64
+	}{
+		{100, 100, 0, 100}, // every response a 500
+		{100, 50, 0, 50},
+		{90, 10, 10, 10}, // 10 connections refused
+		{0, 10, 10, 100},
+		{0, 0, 0, 0},
+	} {
+		if got := ErrorPct(tc.reqs, tc.fails, tc.unanswered); got != tc.want {
+			t.Errorf("ErrorPct(%d, %d, %d) s/b %.0f, got %.2f", tc.reqs, tc.fails, tc.unanswered, tc.want, got)
+		}
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+	c := newCollector(60, log.New(ioutil.Discard, "", 0), time.Now(), nil)
+	for i := int64(100); i >= 1; i-- {
+		c.durationsThisTick = append(c.durationsThisTick, i)
+	}
+	s := c.snapshot(time.Now())
+	if s.LatencyP50Ms != 50 || s.LatencyP95Ms != 95 || s.LatencyP99Ms != 99 {
+		t.Errorf("p50/p95/p99 of 1..100 s/b 50/95/99, got %.0f/%.0f/%.0f", s.LatencyP50Ms, s.LatencyP95Ms, s.LatencyP99Ms)
+	}
+
+	if got := Percentile([]int64{7}, 99); got != 7 {
+		t.Errorf("p99 of one thing s/b that thing, got %.0f", got)
+	}
+	if got := Percentile(nil, 50); got != 0 {
+		t.Errorf("p50 of nothing s/b 0, got %.0f", got)
+	}
+}
+
+func TestReporters(t *testing.T) {
+	s := Snapshot{
+		Time:         time.Date(2014, 10, 22, 13, 14, 15, 0, time.Local),
+		Workers:      4,
+		ReqSec:       120,
+		ReqSecAvg5:   110,
+		ReqSecAvg60:  100,
+		Fails:        2,
+		LatencyMs:    12.345,
+		LatencyP99Ms: 40,
+		BytesPerSec:  1024,
+		Bars:         make([]int64, 60),
+	}
+
+	var buf bytes.Buffer
+	NewTextReporter(&buf).Report(s)
+	want := "13:14:15 workers=4 req/s=120 fails=2 avg/p99=12.35/40.00ms bytes/s=1024.00\n"
+	if buf.String() != want {
+		t.Errorf("text line s/b\n%sgot\n%s", want, buf.String())
+	}
+
+	buf.Reset()
+	NewJSONReporter(&buf).Report(s)
+	if strings.Count(buf.String(), "\n") != 1 {
+		t.Errorf("json s/b one line, got %q", buf.String())
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("json didn't parse: %v", err)
+	}
+	if decoded["workers"] != float64(4) || decoded["fails"] != float64(2) {
+		t.Errorf("json has the wrong numbers: %v", decoded)
+	}
+	if _, ok := decoded["Bars"]; ok {
+		t.Errorf("the bars are for the displays, they shouldn't be in the json")
+	}
+	if _, ok := decoded["faults"]; ok {
+		t.Errorf("faults s/b left out when there aren't any")
+	}
+
+	// the fault total only shows up once there are some
+	s.Faults = map[string]int64{"abort": 3, "bad-header": 2}
+	buf.Reset()
+	NewTextReporter(&buf).Report(s)
+	if !strings.HasSuffix(buf.String(), " bytes/s=1024.00 faults=5\n") {
+		t.Errorf("text line s/b ending in faults=5, got %q", buf.String())
+	}
+}
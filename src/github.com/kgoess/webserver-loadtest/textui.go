@@ -0,0 +1,150 @@
+package main
+
+// The --ui=text frontEnd, for when ncurses is more trouble than it's worth:
+// one line per second on stdout, and the same +/- keys on stdin.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	stats "github.com/kgoess/webserver-loadtest/stats"
+)
+
+type textUI struct {
+	reporter    *stats.TextReporter
+	restoreTerm func()
+}
+
+func startTextUI(
+	infoMsgsCh chan<- ncursesMsg,
+	exitCh chan<- int,
+	changeNumRequestersCh chan<- interface{},
+	resetStatsCh chan<- bool,
+) *textUI {
+	ui := &textUI{
+		reporter:    stats.NewTextReporter(os.Stdout),
+		restoreTerm: setCbreakMode(),
+	}
+	go textRunloop(infoMsgsCh, exitCh, changeNumRequestersCh, resetStatsCh, os.Stdin)
+	return ui
+}
+
+func (ui *textUI) Report(s stats.Snapshot) {
+	ui.reporter.Report(s)
+}
+
+// Every failed request is TMI for a line-per-second display, but the
+// "increasing threads" kind go to stderr so they don't end up in a tee
+func (ui *textUI) ShowMsg(msg ncursesMsg) {
+	if msg.msgType == MSG_TYPE_RESULT {
+		return
+	}
+	if msg.currentCount >= 0 {
+		fmt.Fprintf(os.Stderr, "%s (%d)\n", msg.msgStr, msg.currentCount)
+	} else {
+		fmt.Fprintln(os.Stderr, msg.msgStr)
+	}
+}
+
+func (ui *textUI) Update() {
+}
+
+func (ui *textUI) Resize(size termSize) {
+}
+
+func (ui *textUI) ChangeView(v viewChange) {
+}
+
+func (ui *textUI) Close() {
+	ui.restoreTerm()
+}
+
+// Gets the terminal to hand us keys without waiting for a newline. If
+// stdin isn't a terminal (or there's no stty) you'll just have to hit
+// enter after each key.
+func setCbreakMode() (restore func()) {
+	saved, err := stty("-g")
+	if err != nil {
+		INFO.Println("stdin doesn't look like a terminal, keys will need a newline: ", err)
+		return func() {}
+	}
+	if _, err := stty("cbreak", "-echo"); err != nil {
+		INFO.Println("couldn't put the terminal in cbreak mode: ", err)
+		return func() {}
+	}
+	return func() {
+		if _, err := stty(strings.TrimSpace(saved)); err != nil {
+			ERROR.Println("couldn't restore the terminal: ", err)
+		}
+	}
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// windowRunloop for --ui=text
+func textRunloop(
+	infoMsgsCh chan<- ncursesMsg,
+	exitCh chan<- int,
+	changeNumRequestersCh chan<- interface{},
+	resetStatsCh chan<- bool,
+	in io.Reader,
+) {
+	reader := bufio.NewReader(in)
+	var entry targetEntry
+	for {
+		c, err := reader.ReadByte()
+		if err != nil {
+			// e.g. running under nohup, we just carry on without keys
+			INFO.Println("stdin is closed, no more keyboard control: ", err)
+			return
+		}
+		if entry.active {
+			if target, done := entry.key(int(c), infoMsgsCh); done {
+				changeThreadsTo(infoMsgsCh, changeNumRequestersCh, target)
+			}
+			continue
+		}
+		switch c {
+		case 'q':
+			exitCh <- 0
+		case 's', '+', '=':
+			increaseThreads(infoMsgsCh, changeNumRequestersCh)
+		case '-':
+			decreaseThreads(infoMsgsCh, changeNumRequestersCh)
+		case ']':
+			changeThreadsBy(infoMsgsCh, changeNumRequestersCh, 10)
+		case '[':
+			changeThreadsBy(infoMsgsCh, changeNumRequestersCh, -10)
+		case 't':
+			entry.start(infoMsgsCh)
+		case 'p':
+			togglePause(infoMsgsCh)
+		case 'r':
+			resetStats(infoMsgsCh, resetStatsCh)
+		case 'f':
+			toggleFaults(infoMsgsCh)
+		case '?':
+			fmt.Fprintln(os.Stderr, textHelpText)
+		case 27:
+			// the arrow keys come in as ESC [ A and ESC [ B
+			if b, _ := reader.ReadByte(); b != '[' {
+				continue
+			}
+			switch b, _ := reader.ReadByte(); b {
+			case 'A':
+				increaseThreads(infoMsgsCh, changeNumRequestersCh)
+			case 'B':
+				decreaseThreads(infoMsgsCh, changeNumRequestersCh)
+			}
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package thinktime
+
+// How long a worker waits between its requests, to look more like people
+// clicking around than a tight loop. Specs for --think:
+//
+//	none                   straight on to the next request
+//	250ms                  always the same
+//	uniform:100ms-2s       anywhere in between
+//	normal:1s,200ms        mean and standard deviation, never under 0
+//	exp:1s                 exponential with that mean, i.e. the requests
+//	                       from each worker are a Poisson process
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+type Dist interface {
+	Next() time.Duration
+	String() string
+}
+
+type None struct{}
+
+func (d None) Next() time.Duration { return 0 }
+func (d None) String() string       { return "none" }
+
+type Constant struct {
+	D time.Duration
+}
+
+func (d Constant) Next() time.Duration { return d.D }
+func (d Constant) String() string       { return d.D.String() }
+
+type Uniform struct {
+	Min, Max time.Duration
+}
+
+func (d Uniform) Next() time.Duration {
+	if d.Max <= d.Min {
+		return d.Min
+	}
+	return d.Min + time.Duration(rand.Int63n(int64(d.Max-d.Min)+1))
+}
+func (d Uniform) String() string { return fmt.Sprintf("uniform:%v-%v", d.Min, d.Max) }
+
+type Normal struct {
+	Mean, StdDev time.Duration
+}
+
+func (d Normal) Next() time.Duration {
+	next := time.Duration(rand.NormFloat64()*float64(d.StdDev)) + d.Mean
+	if next < 0 {
+		return 0
+	}
+	return next
+}
+func (d Normal) String() string { return fmt.Sprintf("normal:%v,%v", d.Mean, d.StdDev) }
+
+type Exponential struct {
+	Mean time.Duration
+}
+
+func (d Exponential) Next() time.Duration {
+	return time.Duration(rand.ExpFloat64() * float64(d.Mean))
+}
+func (d Exponential) String() string { return fmt.Sprintf("exp:%v", d.Mean) }
+
+func Parse(spec string) (Dist, error) {
+	kind, args := "", spec
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind, args = spec[:i], spec[i+1:]
+	}
+
+	switch kind {
+	case "":
+		if spec == "none" || spec == "" || spec == "0" {
+			return None{}, nil
+		}
+		d, err := parseDuration(spec)
+		if err != nil {
+			return nil, err
+		}
+		return Constant{d}, nil
+	case "uniform":
+		parts := strings.SplitN(args, "-", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("think time %q s/b uniform:min-max", spec)
+		}
+		min, err := parseDuration(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		max, err := parseDuration(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		if max < min {
+			return nil, fmt.Errorf("think time %q has the max under the min", spec)
+		}
+		return Uniform{min, max}, nil
+	case "normal":
+		parts := strings.SplitN(args, ",", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("think time %q s/b normal:mean,stddev", spec)
+		}
+		mean, err := parseDuration(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		stdDev, err := parseDuration(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		return Normal{mean, stdDev}, nil
+	case "exp", "poisson":
+		mean, err := parseDuration(args)
+		if err != nil {
+			return nil, err
+		}
+		return Exponential{mean}, nil
+	}
+	return nil, fmt.Errorf("unknown think time %q, s/b none, a duration, uniform:, normal: or exp:", spec)
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("bad think time: %v", err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("think time %v can't be negative", d)
+	}
+	return d, nil
+}
+
+// Sleep waits for d, or until ctx is done, whichever's first
+func Sleep(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,88 @@
+package thinktime
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		spec string
+		want Dist
+	}{
+		{"none", None{}},
+		{"0", None{}},
+		{"10ms", Constant{10 * time.Millisecond}},
+		{"uniform:100ms-2s", Uniform{100 * time.Millisecond, 2 * time.Second}},
+		{"normal:1s,200ms", Normal{time.Second, 200 * time.Millisecond}},
+		{"exp:500ms", Exponential{500 * time.Millisecond}},
+		{"poisson:500ms", Exponential{500 * time.Millisecond}},
+	} {
+		got, err := Parse(tc.spec)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", tc.spec, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("Parse(%q) s/b %v, got %v", tc.spec, tc.want, got)
+		}
+	}
+
+	for _, bad := range []string{"fast", "uniform:2s", "uniform:2s-1s", "normal:1s", "exp:soon", "-5ms", "gamma:1s"} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("Parse(%q) s/b an error", bad)
+		}
+	}
+}
+
+// the right shape, roughly
+func TestDistributions(t *testing.T) {
+	const n = 20000
+	for _, tc := range []struct {
+		dist     Dist
+		wantMean time.Duration
+		min, max time.Duration
+	}{
+		{Constant{50 * time.Millisecond}, 50 * time.Millisecond, 50 * time.Millisecond, 50 * time.Millisecond},
+		{Uniform{100 * time.Millisecond, 300 * time.Millisecond}, 200 * time.Millisecond, 100 * time.Millisecond, 300 * time.Millisecond},
+		{Normal{time.Second, 100 * time.Millisecond}, time.Second, 0, 2 * time.Second},
+		{Exponential{200 * time.Millisecond}, 200 * time.Millisecond, 0, time.Hour},
+	} {
+		var sum time.Duration
+		for i := 0; i < n; i++ {
+			d := tc.dist.Next()
+			if d < tc.min || d > tc.max {
+				t.Errorf("%v gave %v, s/b between %v and %v", tc.dist, d, tc.min, tc.max)
+				break
+			}
+			sum += d
+		}
+		mean := sum / n
+		if math.Abs(float64(mean-tc.wantMean)) > float64(tc.wantMean)/20 {
+			t.Errorf("%v mean s/b about %v, got %v", tc.dist, tc.wantMean, mean)
+		}
+	}
+
+	// normal never goes negative even when it's mostly spread
+	d := Normal{10 * time.Millisecond, time.Second}
+	for i := 0; i < 1000; i++ {
+		if x := d.Next(); x < 0 {
+			t.Fatalf("normal gave a negative think time %v", x)
+		}
+	}
+}
+
+func TestSleepCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	t0 := time.Now()
+	Sleep(ctx, time.Minute)
+	if time.Since(t0) > 5*time.Second {
+		t.Errorf("Sleep s/b cut short by the context")
+	}
+}
@@ -0,0 +1,141 @@
+package tmpl
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+)
+
+// how a feeder hands out its rows
+type Mode int
+
+const (
+	Sequential Mode = iota // in order for everybody, starting over at the end
+	Random                 // any row, every time
+	Unique                 // each worker goes through the rows nobody else has right now
+)
+
+func (m Mode) String() string {
+	switch m {
+	case Random:
+		return "random"
+	case Unique:
+		return "unique"
+	}
+	return "sequential"
+}
+
+func ParseMode(s string) (Mode, error) {
+	switch s {
+	case "", "sequential", "seq":
+		return Sequential, nil
+	case "random":
+		return Random, nil
+	case "unique":
+		return Unique, nil
+	}
+	return Sequential, fmt.Errorf("feeder mode %q s/b sequential, random or unique", s)
+}
+
+// A Feeder is a table of values, a csv file with the column names on the
+// first line
+type Feeder struct {
+	Name    string
+	Columns []string
+
+	mode   Mode
+	rows   [][]string
+	mu     sync.Mutex
+	cursor int
+
+	// for Unique, the rows by index
+	free []int       // nobody has them, the longest unused first
+	held map[int]int // by worker
+}
+
+func LoadCSV(path string, mode Mode) (*Feeder, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("can't read %s: %v", path, err)
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("%s s/b a line of column names and at least one row", path)
+	}
+	return NewFeeder(path, records[0], records[1:], mode), nil
+}
+
+func NewFeeder(name string, columns []string, rows [][]string, mode Mode) *Feeder {
+	f := &Feeder{
+		Name:    name,
+		Columns: columns,
+		mode:    mode,
+		rows:    rows,
+		held:    make(map[int]int),
+	}
+	for i := range rows {
+		f.free = append(f.free, i)
+	}
+	return f
+}
+
+func (f *Feeder) next(worker int) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.rows) == 0 {
+		return nil, fmt.Errorf("%s has no rows", f.Name)
+	}
+	switch f.mode {
+	case Random:
+		return f.rows[rand.Intn(len(f.rows))], nil
+	case Unique:
+		// give back the last one and take the next, if there's a next
+		if i, ok := f.held[worker]; ok {
+			f.free = append(f.free, i)
+		} else if len(f.free) == 0 {
+			return nil, fmt.Errorf("%s has run out, there are more workers than its %d rows", f.Name, len(f.rows))
+		}
+		i := f.free[0]
+		f.free = f.free[1:]
+		f.held[worker] = i
+		return f.rows[i], nil
+	}
+	row := f.rows[f.cursor]
+	f.cursor = (f.cursor + 1) % len(f.rows)
+	return row, nil
+}
+
+// release gives back the worker's Unique row, once it's stopped for good
+func (f *Feeder) release(worker int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if i, ok := f.held[worker]; ok {
+		f.free = append(f.free, i)
+		delete(f.held, worker)
+	}
+}
+
+func (f *Feeder) columnFunc(column string) (func(*Env) (string, error), error) {
+	col := -1
+	for i, name := range f.Columns {
+		if name == column {
+			col = i
+		}
+	}
+	if col < 0 {
+		return nil, fmt.Errorf("%s has no %q column", f.Name, column)
+	}
+	return func(env *Env) (string, error) {
+		row, err := env.row(f)
+		if err != nil {
+			return "", err
+		}
+		return row[col], nil
+	}, nil
+}
@@ -0,0 +1,285 @@
+package tmpl
+
+// Placeholders in the --url, --header, --body and --script steps, filled
+// in fresh for every request so the load gets spread over lots of
+// products and accounts instead of one url that's sitting in a cache:
+//
+//	{{user_id}}                   a variable: worker, iteration, a value a
+//	                              --script step extracted, or a column of
+//	                              a --feed file
+//	{{rand_int 1 100000}}         a random int, both ends included
+//	{{uuid}}                      a random (v4) uuid
+//	{{csv "users.csv" "email"}}   a column from a csv file, optionally
+//	                              with a mode after it, see Mode
+//
+// Everything in one request (or one pass through a --script) gets the
+// same row from each file, so the email and the password go together.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	mathrand "math/rand"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A Set holds the feeders the templates are using, and is what parses
+// them
+type Set struct {
+	mu      sync.Mutex
+	files   map[string]*Feeder // for {{csv}}, by file name
+	columns map[string]*Feeder // --feed columns that are variables
+}
+
+func NewSet() *Set {
+	return &Set{files: make(map[string]*Feeder), columns: make(map[string]*Feeder)}
+}
+
+// Feed makes the feeder's columns into variables, and lets {{csv}} use it
+// too. Do this before parsing anything that uses them.
+func (s *Set) Feed(f *Feeder) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, col := range f.Columns {
+		if other, ok := s.columns[col]; ok {
+			return fmt.Errorf("%s and %s both have a %q column", other.Name, f.Name, col)
+		}
+	}
+	for _, col := range f.Columns {
+		s.columns[col] = f
+	}
+	s.files[f.Name] = f
+	return nil
+}
+
+// Release is for when a worker's stopped for good, so the :unique rows
+// it had can go to somebody else
+func (s *Set) Release(worker int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.files {
+		f.release(worker)
+	}
+}
+
+// the feeder for {{csv}}, loading the file the first time
+func (s *Set) file(name string, mode Mode, explicit bool) (*Feeder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.files[name]; ok {
+		if explicit && f.mode != mode {
+			return nil, fmt.Errorf("%s is already being read %v", name, f.mode)
+		}
+		return f, nil
+	}
+	f, err := LoadCSV(name, mode)
+	if err != nil {
+		return nil, err
+	}
+	s.files[name] = f
+	return f, nil
+}
+
+// Env is what one request's placeholders get filled in from. Vars can be
+// changed in between Expands, e.g. as --script steps extract things.
+type Env struct {
+	Worker int
+	Vars   map[string]string
+	rows   map[*Feeder][]string
+}
+
+func NewEnv(worker int, vars map[string]string) *Env {
+	if vars == nil {
+		vars = make(map[string]string)
+	}
+	return &Env{Worker: worker, Vars: vars, rows: make(map[*Feeder][]string)}
+}
+
+// the same row for everything in this env
+func (e *Env) row(f *Feeder) ([]string, error) {
+	if row, ok := e.rows[f]; ok {
+		return row, nil
+	}
+	row, err := f.next(e.Worker)
+	if err != nil {
+		return nil, err
+	}
+	e.rows[f] = row
+	return row, nil
+}
+
+type Template struct {
+	text   string
+	parts  []func(env *Env) (string, error)
+	static bool
+}
+
+func (t *Template) String() string {
+	return t.text
+}
+
+// Static is true if there's nothing to fill in
+func (t *Template) Static() bool {
+	return t.static
+}
+
+func (t *Template) Expand(env *Env) (string, error) {
+	if len(t.parts) == 1 {
+		return t.parts[0](env)
+	}
+	var out bytes.Buffer
+	for _, p := range t.parts {
+		s, err := p(env)
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(s)
+	}
+	return out.String(), nil
+}
+
+// Parse checks the functions and their arguments, and loads any csv
+// files, so mistakes show up before the test starts. Variables that
+// aren't --feed columns can't be checked until they're used.
+func (s *Set) Parse(text string) (*Template, error) {
+	t := &Template{text: text, static: true}
+	rest := text
+	for rest != "" {
+		start := strings.Index(rest, "{{")
+		if start < 0 {
+			t.parts = append(t.parts, literal(rest))
+			break
+		}
+		if start > 0 {
+			t.parts = append(t.parts, literal(rest[:start]))
+		}
+		end := strings.Index(rest[start:], "}}")
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed {{ in %q", text)
+		}
+		p, err := s.placeholder(rest[start+2 : start+end])
+		if err != nil {
+			return nil, fmt.Errorf("%v in %q", err, text)
+		}
+		t.parts = append(t.parts, p)
+		t.static = false
+		rest = rest[start+end+2:]
+	}
+	if len(t.parts) == 0 {
+		t.parts = append(t.parts, literal(""))
+	}
+	return t, nil
+}
+
+func literal(s string) func(*Env) (string, error) {
+	return func(*Env) (string, error) { return s, nil }
+}
+
+func (s *Set) placeholder(inside string) (func(*Env) (string, error), error) {
+	words, err := split(inside)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("empty {{}}")
+	}
+	name, args := words[0], words[1:]
+
+	switch name {
+	case "rand_int":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("rand_int s/b {{rand_int min max}}")
+		}
+		min, err1 := strconv.ParseInt(args[0], 10, 64)
+		max, err2 := strconv.ParseInt(args[1], 10, 64)
+		if err1 != nil || err2 != nil || max < min {
+			return nil, fmt.Errorf("rand_int needs two whole numbers, the smaller first")
+		}
+		return func(*Env) (string, error) {
+			return strconv.FormatInt(min+mathrand.Int63n(max-min+1), 10), nil
+		}, nil
+
+	case "uuid":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("uuid doesn't take arguments")
+		}
+		return func(*Env) (string, error) { return uuid(), nil }, nil
+
+	case "csv":
+		if len(args) != 2 && len(args) != 3 {
+			return nil, fmt.Errorf(`csv s/b {{csv "file" "column"}} or {{csv "file" "column" "mode"}}`)
+		}
+		mode := Sequential
+		if len(args) == 3 {
+			if mode, err = ParseMode(args[2]); err != nil {
+				return nil, err
+			}
+		}
+		f, err := s.file(args[0], mode, len(args) == 3)
+		if err != nil {
+			return nil, err
+		}
+		return f.columnFunc(args[1])
+	}
+
+	if len(args) > 0 {
+		return nil, fmt.Errorf("unknown function %q", name)
+	}
+	s.mu.Lock()
+	f := s.columns[name]
+	s.mu.Unlock()
+	if f != nil {
+		return f.columnFunc(name)
+	}
+	return func(env *Env) (string, error) {
+		val, ok := env.Vars[name]
+		if !ok {
+			return "", fmt.Errorf("no value for {{%s}}", name)
+		}
+		return val, nil
+	}, nil
+}
+
+// splits on spaces, except inside double quotes
+func split(s string) ([]string, error) {
+	var words []string
+	s = strings.TrimSpace(s)
+	for s != "" {
+		if s[0] == '"' {
+			end := 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, fmt.Errorf("unclosed quote")
+			}
+			word, err := strconv.Unquote(s[:end+1])
+			if err != nil {
+				return nil, err
+			}
+			words = append(words, word)
+			s = strings.TrimSpace(s[end+1:])
+			continue
+		}
+		end := strings.IndexAny(s, " \t")
+		if end < 0 {
+			end = len(s)
+		}
+		words = append(words, s[:end])
+		s = strings.TrimSpace(s[end:])
+	}
+	return words, nil
+}
+
+func uuid() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // the RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,231 @@
+package tmpl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func writeCSV(t *testing.T, contents string) string {
+	dir, err := ioutil.TempDir("", "tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "users.csv")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func mustExpand(t *testing.T, tmpl *Template, env *Env) string {
+	out, err := tmpl.Expand(env)
+	if err != nil {
+		t.Fatalf("Expand(%s) failed: %v", tmpl, err)
+	}
+	return out
+}
+
+func TestVarsAndFunctions(t *testing.T) {
+	set := NewSet()
+	tm, err := set.Parse("/product/{{rand_int 5 7}}?u={{ user }}&req={{uuid}}")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if tm.Static() {
+		t.Errorf("Static s/b false")
+	}
+	re := regexp.MustCompile(`^/product/([5-7])\?u=bob&req=[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	seen := make(map[string]bool)
+	for i := 0; i < 200; i++ {
+		out := mustExpand(t, tm, NewEnv(0, map[string]string{"user": "bob"}))
+		m := re.FindStringSubmatch(out)
+		if m == nil {
+			t.Fatalf("got %q, s/b like %s", out, re)
+		}
+		seen[m[1]] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("rand_int 5 7 s/b 5, 6 and 7, got %v", seen)
+	}
+
+	if _, err := tm.Expand(NewEnv(0, nil)); err == nil {
+		t.Errorf("missing variable s/b an error")
+	}
+
+	plain, _ := set.Parse("http://example.com/")
+	if !plain.Static() || mustExpand(t, plain, nil) != "http://example.com/" {
+		t.Errorf("no placeholders s/b static and unchanged")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	set := NewSet()
+	for _, bad := range []string{
+		"/{{uuid",
+		"/{{}}",
+		"/{{rand_int 1}}",
+		"/{{rand_int 10 1}}",
+		"/{{rand_int a b}}",
+		"/{{uuid 4}}",
+		"/{{frobnicate 1 2}}",
+		`/{{csv "nonexistent.csv" "email"}}`,
+		`/{{csv "unclosed}}`,
+	} {
+		if _, err := set.Parse(bad); err == nil {
+			t.Errorf("Parse(%q) s/b an error", bad)
+		}
+	}
+}
+
+func TestCSVSequential(t *testing.T) {
+	path := writeCSV(t, "email,password\na@x,pa\nb@x,pb\nc@x,pc\n")
+	defer os.RemoveAll(filepath.Dir(path))
+
+	set := NewSet()
+	tm, err := set.Parse(`{{csv "` + path + `" "email"}}:{{csv "` + path + `" "password"}}`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	// the same row for both columns, and round we go
+	for _, want := range []string{"a@x:pa", "b@x:pb", "c@x:pc", "a@x:pa"} {
+		if got := mustExpand(t, tm, NewEnv(0, nil)); got != want {
+			t.Errorf("s/b %q, got %q", want, got)
+		}
+	}
+
+	// one env keeps its row across templates
+	other, _ := set.Parse(`{{csv "` + path + `" "password"}}`)
+	env := NewEnv(0, nil)
+	if a, b := mustExpand(t, tm, env), mustExpand(t, other, env); a != "b@x:"+b {
+		t.Errorf("same env s/b the same row, got %q and %q", a, b)
+	}
+
+	if _, err := set.Parse(`{{csv "` + path + `" "phone"}}`); err == nil {
+		t.Errorf("unknown column s/b an error")
+	}
+	if _, err := set.Parse(`{{csv "` + path + `" "email" "random"}}`); err == nil {
+		t.Errorf("a different mode for the same file s/b an error")
+	}
+}
+
+func TestFeedModes(t *testing.T) {
+	rows := [][]string{{"1"}, {"2"}, {"3"}}
+
+	set := NewSet()
+	if err := set.Feed(NewFeeder("ids", []string{"id"}, rows, Unique)); err != nil {
+		t.Fatalf("Feed failed: %v", err)
+	}
+	if err := set.Feed(NewFeeder("more", []string{"id"}, rows, Random)); err == nil {
+		t.Errorf("two feeders with the same column s/b an error")
+	}
+	tm, err := set.Parse("{{id}}")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	// each worker sticks with its own
+	got := make(map[int]string)
+	for round := 0; round < 2; round++ {
+		for worker := 0; worker < 3; worker++ {
+			id := mustExpand(t, tm, NewEnv(worker, nil))
+			if round > 0 && got[worker] != id {
+				t.Errorf("worker %d s/b still %s, got %s", worker, got[worker], id)
+			}
+			got[worker] = id
+		}
+	}
+	if got[0] == got[1] || got[1] == got[2] || got[0] == got[2] {
+		t.Errorf("workers s/b all different, got %v", got)
+	}
+	if _, err := tm.Expand(NewEnv(3, nil)); err == nil {
+		t.Errorf("a fourth worker s/b out of rows")
+	}
+
+	// until one of the others stops
+	set.Release(1)
+	if id := mustExpand(t, tm, NewEnv(3, nil)); id != got[1] {
+		t.Errorf("the fourth worker s/b getting worker 1's %s, got %s", got[1], id)
+	}
+
+	set = NewSet()
+	set.Feed(NewFeeder("ids", []string{"id"}, rows, Random))
+	tm, _ = set.Parse("{{id}}")
+	counts := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		counts[mustExpand(t, tm, NewEnv(0, nil))]++
+	}
+	for _, row := range rows {
+		if counts[row[0]] < 50 {
+			t.Errorf("random s/b spread out, got %v", counts)
+		}
+	}
+
+	for _, tc := range []struct {
+		s    string
+		want Mode
+	}{{"", Sequential}, {"seq", Sequential}, {"random", Random}, {"unique", Unique}} {
+		if m, err := ParseMode(tc.s); err != nil || m != tc.want {
+			t.Errorf("ParseMode(%q) s/b %v, got %v %v", tc.s, tc.want, m, err)
+		}
+	}
+	if _, err := ParseMode("shuffled"); err == nil {
+		t.Errorf("ParseMode(shuffled) s/b an error")
+	}
+}
+
+// workers coming and going, like --search or pressing + and -, with a
+// lot more of them over the run than there are rows
+func TestUniqueAddRemove(t *testing.T) {
+	set := NewSet()
+	set.Feed(NewFeeder("ids", []string{"id"}, [][]string{{"1"}, {"2"}, {"3"}}, Unique))
+	tm, _ := set.Parse("{{id}}")
+
+	// one worker goes round all of them
+	var seen []string
+	for i := 0; i < 4; i++ {
+		seen = append(seen, mustExpand(t, tm, NewEnv(0, nil)))
+	}
+	if strings.Join(seen, ",") != "1,2,3,1" {
+		t.Errorf("one worker s/b going through them in turn, got %v", seen)
+	}
+
+	// never two workers on the same row at once
+	holding := map[int]string{0: seen[3]}
+	for worker := 1; worker < 100; worker++ {
+		id, err := tm.Expand(NewEnv(worker, nil))
+		if err != nil {
+			t.Fatalf("worker %d: %v", worker, err)
+		}
+		for other, theirs := range holding {
+			if theirs == id {
+				t.Fatalf("workers %d and %d both have %s", worker, other, id)
+			}
+		}
+		holding[worker] = id
+		if len(holding) == 3 {
+			// the oldest one's removed
+			oldest := worker - 2
+			set.Release(oldest)
+			delete(holding, oldest)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	words, err := split(` csv  "a file.csv" "say \"hi\"" 3 `)
+	if err != nil {
+		t.Fatalf("split failed: %v", err)
+	}
+	want := []string{"csv", "a file.csv", `say "hi"`, "3"}
+	if len(words) != len(want) {
+		t.Fatalf("s/b %q, got %q", want, words)
+	}
+	for i := range want {
+		if words[i] != want[i] {
+			t.Errorf("word %d s/b %q, got %q", i, want[i], words[i])
+		}
+	}
+}
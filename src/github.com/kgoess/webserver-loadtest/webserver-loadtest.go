@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -11,14 +12,27 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 	//"io"
-	gc "code.google.com/p/goncurses"
+	adaptive "github.com/kgoess/webserver-loadtest/adaptive"
 	bcast "github.com/kgoess/webserver-loadtest/bcast"
-	rb "github.com/kgoess/webserver-loadtest/ringbuffer"
+	capacity "github.com/kgoess/webserver-loadtest/capacity"
+	faults "github.com/kgoess/webserver-loadtest/faults"
+	hitid "github.com/kgoess/webserver-loadtest/hitid"
+	httpclient "github.com/kgoess/webserver-loadtest/httpclient"
+	metrics "github.com/kgoess/webserver-loadtest/metrics"
+	session "github.com/kgoess/webserver-loadtest/session"
+	sinks "github.com/kgoess/webserver-loadtest/sinks"
 	slave "github.com/kgoess/webserver-loadtest/slave"
+	stats "github.com/kgoess/webserver-loadtest/stats"
+	thinktime "github.com/kgoess/webserver-loadtest/thinktime"
+	tmpl "github.com/kgoess/webserver-loadtest/tmpl"
+	webui "github.com/kgoess/webserver-loadtest/webui"
+	workerpool "github.com/kgoess/webserver-loadtest/workerpool"
 )
 
 var (
@@ -31,18 +45,26 @@ var (
 const (
 	MSG_TYPE_RESULT int = 0
 	MSG_TYPE_INFO   int = 1
+	MSG_TYPE_PROMPT int = 2 // typing in progress, not worth keeping in the event log
 )
 
 type ncursesMsg struct {
 	msgStr       string
 	currentCount int
 	msgType      int
+	hitId        string // for failed requests, so you can find them in the server's logs
 }
 
-type bytesPerSecMsg struct {
-	bytes         int64
-	duration      time.Duration
-	receivedOnSec int
+// frontEnd is the interactive part, --ui=curses or --ui=text. Both of
+// them read keys in their own goroutine and get everything else from the
+// main loop in realMain.
+type frontEnd interface {
+	stats.Reporter
+	ShowMsg(msg ncursesMsg)
+	Update() // called after every pass through the main loop
+	Resize(size termSize)
+	ChangeView(v viewChange)
+	Close()
 }
 
 type SecondStats struct {
@@ -52,39 +74,252 @@ type SecondStats struct {
 	Duration time.Duration
 }
 
-type currentBars struct {
-	cols     []int64
-	failCols []int64
-	max      int64
+var testUrl = flag.String("url", "", "the url you want to beat on, can have placeholders like {{rand_int 1 1000}}, see README.md, or unix:///path/to.sock:/request/path for a unix socket, or ws:// or wss:// for websockets")
+var hostHeader = flag.String("host", "localhost", "Host header for the requests to a unix:// --url")
+var method = flag.String("method", "GET", "HTTP method for the --url")
+var body = flag.String("body", "", "request body for the --url, can have placeholders")
+var uiMode = flag.String("ui", "curses", "curses, or text for one line per second on stdout")
+var logFile = flag.String("logfile", "./loadtest.log", "path to log file (default loadtest.log)")
+var listen = flag.Int("listen", 0, "listen as a client for controller commands on this port")
+var introduceRandomFails = flag.Int("random-fails", 0, "introduce x/10 random failures, the same as --fault bad-path:x0")
+var faultDelay = flag.Duration("fault-delay", 5*time.Second, "how long the delay --fault stalls in the middle of the headers")
+var webPort = flag.Int("web", 0, "serve a live web dashboard on this port")
+var metricsPort = flag.Int("metrics-port", 0, "serve prometheus metrics on this port at /metrics (can be the same as --web)")
+var nodeName = flag.String("node", "", "name for this box in the metrics labels (default hostname)")
+var sinkPrefix = flag.String("sink-prefix", "loadtest", "metric prefix (statsd/graphite) or measurement name (influx) for --sink")
+var searchMode = flag.Bool("search", false, "step the workers up automatically to find the most the server can take within the SLO")
+var searchStart = flag.Int("search-start", 1, "workers to start the --search at")
+var searchStep = flag.Int("search-step", 10, "workers to add at each --search level")
+var searchMax = flag.Int("search-max", 1000, "never --search past this many workers")
+var searchHold = flag.Duration("search-hold", 10*time.Second, "how long to measure each --search level")
+var sloP99 = flag.Duration("slo-p99", 500*time.Millisecond, "p99 latency limit for --search")
+var sloErrors = flag.Float64("slo-errors", 1, "error percentage limit for --search and --target-p95")
+var targetP95 = flag.Duration("target-p95", 0, "keep adjusting the workers to hold p95 latency here, e.g. 200ms, for soak tests")
+var adaptEvery = flag.Duration("adapt-every", 5*time.Second, "how long --target-p95 measures before each adjustment")
+var adaptMax = flag.Int("adapt-max", 1000, "never go past this many workers for --target-p95")
+var thinkSpec = flag.String("think", "10ms", "each worker's pause between requests: none, 250ms, uniform:100ms-2s, normal:1s,200ms or exp:1s")
+var drainTimeout = flag.Duration("drain", 5*time.Second, "on exit, how long to let requests in flight finish before aborting them")
+var hitIdSpec = flag.String("hitid", "query", "where to put each request's correlation id: off, query, query:param, header or header:Name (X-Request-Id by default)")
+var runId = flag.String("run-id", "", "the run part of the correlation ids, give every node the same one to grep for the whole run (default random)")
+var scriptFile = flag.String("script", "", "run each worker as a virtual user going through the steps in this JSON script, see README.md")
+var caFile = flag.String("cacert", "", "PEM file of CAs to trust for https instead of the system ones")
+var certFile = flag.String("cert", "", "client certificate (PEM) for servers that want mTLS, needs --key")
+var keyFile = flag.String("key", "", "private key (PEM) for the --cert")
+var insecure = flag.Bool("insecure", false, "don't verify the server's certificate, e.g. for self-signed staging certs")
+var sniName = flag.String("sni", "", "server name to send in the TLS handshake and verify the certificate against (default the url's host)")
+var tlsMin = flag.String("tls-min", "", "lowest TLS version to allow: 1.0, 1.1, 1.2 or 1.3")
+var tlsMax = flag.String("tls-max", "", "highest TLS version to allow: 1.0, 1.1, 1.2 or 1.3")
+var ciphers = flag.String("ciphers", "", "comma separated cipher suites to offer, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 (TLS 1.2 and under)")
+var tlsResume = flag.Bool("tls-resume", true, "resume TLS sessions on new connections, --tls-resume=false for a full handshake every time")
+var proxyUrl = flag.String("proxy", "", "tunnel every connection through this proxy, http://[user:pass@]host:port for CONNECT or socks5://[user:pass@]host:port")
+var http2 = flag.Bool("http2", false, "multiplex the requests over HTTP/2 connections, h2 for https urls and h2c (prior knowledge) for http ones")
+var h2Conns = flag.Int("h2-conns", 1, "how many connections --http2 spreads the requests over")
+var h2Streams = flag.Int("h2-streams", 0, "most streams at once on each --http2 connection, the rest wait (default 0 is the server's limit)")
+var wsRate = flag.Float64("ws-rate", 1, "messages a second each websocket sends, the --ws-send ones in turn or pings (0 to just listen)")
+var wsWait = flag.Duration("ws-wait", 10*time.Second, "how long a websocket message waits for its reply before it's a fail (0 to not expect replies)")
+var resolvePick = flag.String("resolve-pick", "round-robin", "how each new connection picks from the --resolve ips: round-robin or random")
+var history = flag.Duration("history", time.Hour, "how much per-second history to keep for scrolling back (and for the web dashboard)")
+
+var slaveList slave.Slaves
+
+// the requester goroutines, the pause key needs to get at them
+var requesters *workerpool.Pool
+
+var thinkTime thinktime.Dist
+
+// the one transport all the requests go through, with the --cacert etc.
+var httpClient *httpclient.Client
+var sharedClient *http.Client
+var resolveSpecs stringList
+var unixSocket string
+var sourceIPs stringList
+
+// the --fault settings, 'f' turns it on and off
+var faultInjector *faults.Injector
+var faultSpecs stringList
+
+// puts the --hitid on the requests
+var hitIds *hitid.Tagger
+
+// for --script, nil if we're just hitting the --url
+var userScript *session.Script
+
+// --feed files and any csvs the placeholders mention
+var templates = tmpl.NewSet()
+var plainRequest *requestTemplate
+var feedSpecs stringList
+var headerSpecs stringList
+
+// --url, --method, --header and --body, with their placeholders parsed
+type requestTemplate struct {
+	method  string
+	url     *tmpl.Template
+	headers []headerTemplate
+	body    *tmpl.Template
 }
 
-type colorsDefined struct {
-	whiteOnBlack int16
-	greenOnBlack int16
-	redOnBlack   int16
+type headerTemplate struct {
+	name  string
+	value *tmpl.Template
 }
 
-var testUrl = flag.String("url", "", "the url you want to beat on")
-var logFile = flag.String("logfile", "./loadtest.log", "path to log file (default loadtest.log)")
-var listen = flag.Int("listen", 0, "listen as a client for controller commands on this port")
-var introduceRandomFails = flag.Int("random-fails", 0, "introduce x/10 random failures")
+func parseRequestTemplate(method string, rawUrl string, headers []string, body string) (*requestTemplate, error) {
+	var err error
+	r := &requestTemplate{method: strings.ToUpper(method)}
+	if r.url, err = templates.Parse(rawUrl); err != nil {
+		return nil, fmt.Errorf("--url: %v", err)
+	}
+	if r.body, err = templates.Parse(body); err != nil {
+		return nil, fmt.Errorf("--body: %v", err)
+	}
+	for _, h := range headers {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("--header %q s/b Name: value", h)
+		}
+		value, err := templates.Parse(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("--header: %v", err)
+		}
+		r.headers = append(r.headers, headerTemplate{strings.TrimSpace(parts[0]), value})
+	}
+	return r, nil
+}
 
-var slaveList slave.Slaves
+// --feed users.csv or --feed users.csv:random
+func loadFeeds(specs []string) error {
+	for _, spec := range specs {
+		path, mode := spec, tmpl.Sequential
+		if i := strings.LastIndex(spec, ":"); i >= 0 {
+			if m, err := tmpl.ParseMode(spec[i+1:]); err == nil {
+				path, mode = spec[:i], m
+			}
+		}
+		f, err := tmpl.LoadCSV(path, mode)
+		if err != nil {
+			return err
+		}
+		if err := templates.Feed(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var sinkSpecs stringList
+var reportSpecs stringList
+
+// for flags you can give more than once
+type stringList []string
+
+func (l *stringList) String() string {
+	return fmt.Sprint(*l)
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
 
 // Remember Exit(0) is success, Exit(1) is failure
 func main() {
 	flag.Var(&slaveList, "control", "list of ip:port addresses to control")
+	flag.Var(&reportSpecs, "report", "also write per-second stats to a file as text:path or json:path (can be repeated)")
+	flag.Var(&faultSpecs, "fault", "misbehave on purpose for a percentage of the requests, kind:percent where kind is "+strings.Join(faults.Kinds, ", ")+" (can be repeated)")
+	flag.Var(&feedSpecs, "feed", "csv file whose columns can be used as {{column}} placeholders, add :random or :unique to change from sequential (can be repeated)")
+	flag.Var(&headerSpecs, "header", "\"Name: value\" header to send with the --url, can have placeholders (can be repeated)")
+	flag.Var(&resolveSpecs, "resolve", "send the requests for host:port to these backends instead, host:port:ip[,ip...] like curl's, keeping the Host header and SNI (can be repeated)")
+	flag.Var(&sourceIPs, "source-ip", "local address to connect from, give several (or a comma separated list) and each new connection takes the next (can be repeated)")
+	flag.Var(&wsSendSpecs, "ws-send", "message for each websocket to send, can have placeholders like {{seq}} (can be repeated, they're sent in turn)")
+	flag.Var(&sinkSpecs, "sink", "push per-second stats to statsd+udp://host:port, graphite+tcp://host:port or influx+udp://host:port (can be repeated)")
 	flag.Parse()
 	if len(*testUrl) == 0 {
 		flag.Usage()
 		os.Exit(1)
 	}
+	if *uiMode != "curses" && *uiMode != "text" {
+		fmt.Fprintf(os.Stderr, "--ui s/b curses or text\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *searchMode && (*searchStart < 1 || *searchStep < 1 || *searchHold < time.Second) {
+		fmt.Fprintf(os.Stderr, "--search-start and --search-step s/b at least 1, --search-hold at least 1s\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *targetP95 > 0 && *adaptEvery < time.Second {
+		fmt.Fprintf(os.Stderr, "--adapt-every s/b at least 1s\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	var err error
+	if thinkTime, err = thinktime.Parse(*thinkSpec); err != nil {
+		fmt.Fprintf(os.Stderr, "--think: %v\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
+	if httpclient.IsUnixURL(*testUrl) {
+		// from here on it's an http url that just happens to get dialed
+		// on the socket
+		var path string
+		if unixSocket, path, err = httpclient.ParseUnixURL(*testUrl); err != nil {
+			fmt.Fprintf(os.Stderr, "--url %v\n", err)
+			os.Exit(1)
+		}
+		*testUrl = "http://" + *hostHeader + path
+	}
+	if err = loadFeeds(feedSpecs); err != nil {
+		fmt.Fprintf(os.Stderr, "--feed: %v\n", err)
+		os.Exit(1)
+	}
+	if plainRequest, err = parseRequestTemplate(*method, *testUrl, headerSpecs, *body); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if isWebSocketURL(*testUrl) {
+		if *scriptFile != "" || *http2 || unixSocket != "" {
+			fmt.Fprintf(os.Stderr, "--script, --http2 and unix sockets don't go with a websocket --url\n")
+			os.Exit(1)
+		}
+		if *wsRate < 0 || *wsWait < 0 {
+			fmt.Fprintf(os.Stderr, "--ws-rate and --ws-wait can't be negative\n")
+			os.Exit(1)
+		}
+		if err = parseWSMessages(wsSendSpecs); err != nil {
+			fmt.Fprintf(os.Stderr, "--ws-send: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *scriptFile != "" {
+		if userScript, err = session.Load(*scriptFile, *testUrl, templates); err != nil {
+			fmt.Fprintf(os.Stderr, "--script: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *searchMode && *targetP95 > 0 {
+		fmt.Fprintf(os.Stderr, "You can't have both --search and --target-p95\n")
+		flag.Usage()
+		os.Exit(1)
+	}
 	if len(slaveList) > 0 && *listen != 0 {
 		fmt.Fprintf(os.Stderr, "You can't have both --listen and --control flags")
 		flag.Usage()
 		os.Exit(1)
 	}
+	if *history < time.Minute {
+		fmt.Fprintf(os.Stderr, "--history s/b at least 1m\n")
+		flag.Usage()
+		os.Exit(1)
+	}
 	rand.Seed(time.Now().Unix())
+	if *nodeName == "" {
+		*nodeName, _ = os.Hostname()
+	}
+	if hitIds, err = hitid.Parse(*hitIdSpec, *runId, *nodeName); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
 
 	// set up logging
 	logWriter, err := os.OpenFile(*logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
@@ -103,24 +338,135 @@ func main() {
 	ERROR = log.New(logWriter,
 		"ERROR: ",
 		log.Ldate|log.Ltime|log.Lshortfile)
-	INFO.Println("beginning run")
+	INFO.Println("beginning run ", hitIds.RunID)
+
+	httpClient, err = httpclient.MakeNew(httpclient.Config{
+		CAFile:     *caFile,
+		CertFile:   *certFile,
+		KeyFile:    *keyFile,
+		Insecure:   *insecure,
+		ServerName: *sniName,
+		MinVersion: *tlsMin,
+		MaxVersion: *tlsMax,
+		Ciphers:    *ciphers,
+		NoResume:   !*tlsResume,
+
+		Resolve:     resolveSpecs,
+		ResolvePick: *resolvePick,
+		SourceIPs:   sourceIPs,
+		Proxy:       *proxyUrl,
+		UnixSocket:  unixSocket,
+
+		HTTP2:     *http2,
+		H2Conns:   *h2Conns,
+		H2Streams: *h2Streams,
+	}, INFO)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	sharedClient = httpClient.HTTPClient(nil)
+	if userScript != nil {
+		userScript.Transport = httpClient.Transport
+	}
+
+	faultInjector = faults.MakeNew(INFO)
+	faultInjector.Delay = *faultDelay
+	faultInjector.WrapTLS = httpClient.WrapTLS
+	faultInjector.Dial = httpClient.DialContext
+	if *introduceRandomFails > 0 {
+		faultSpecs = append([]string{fmt.Sprintf("%s:%d", faults.BadPath, *introduceRandomFails*10)}, faultSpecs...)
+	}
+	for _, spec := range faultSpecs {
+		if err := faultInjector.Add(spec); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	}
+	if faultInjector.Empty() {
+		// so 'f' still does what it always did
+		faultInjector.Set(faults.BadPath, 30)
+		faultInjector.SetEnabled(false)
+	}
+	INFO.Println("faults: ", faultInjector, " on: ", faultInjector.Enabled())
 
 	os.Exit(realMain())
 }
 
-type resetScreenFn func()
-
 // Why realMain? See https://groups.google.com/forum/#!topic/golang-nuts/_Twwb5ULStM
 // So that defer will run propoerly
 func realMain() (exitStatus int) {
 
-	// initialize ncurses
-	stdscr, colors, resetScreen := initializeNcurses()
+	// connect to the metric sinks and open the report files before we
+	// take over the screen, so any errors are readable
+	metricSinks, err := dialSinks(sinkSpecs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't set up --sink: %v\n", err)
+		return 1
+	}
+	reporters, err := openReporters(reportSpecs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't set up --report: %v\n", err)
+		return 1
+	}
+	if len(metricSinks) > 0 {
+		reporters = append(reporters, newSinkReporter(metricSinks))
+	}
+
+	// create our various channels
+	infoMsgsCh := make(chan ncursesMsg)
+	exitCh := make(chan int)
+	changeNumRequestersCh := make(chan interface{})
+	changeNumRequestersListenerCh := make(chan interface{})
+	numRequestersStatsCh := make(chan interface{})
+	reqMadeOnSecCh := make(chan interface{})
+	reqMadeOnSecListenerCh := make(chan interface{})
+	reqMadeOnSecSlaveListenerCh := make(chan interface{})
+	failsOnSecCh := make(chan interface{})
+	failsOnSecListenerCh := make(chan interface{})
+	durationCh := make(chan interface{})
+	durationListenerCh := make(chan interface{})
+	bytesPerSecCh := make(chan interface{})
+	bytesPerSecListenerCh := make(chan interface{})
+	snapshotCh := make(chan stats.Snapshot)
+	resizeCh := make(chan termSize)
+	viewCh := make(chan viewChange)
+	resetStatsCh := make(chan bool)
+	drainedCh := make(chan bool)
+
+	work := func(ctx context.Context, id int, i int64) {
+		makeRequest(ctx, i, infoMsgsCh, id, reqMadeOnSecCh, failsOnSecCh, durationCh, bytesPerSecCh, plainRequest)
+	}
+	if userScript != nil {
+		work = func(ctx context.Context, id int, i int64) {
+			runSession(ctx, i, infoMsgsCh, id, reqMadeOnSecCh, failsOnSecCh, durationCh, bytesPerSecCh)
+		}
+	}
+	if isWebSocketURL(*testUrl) {
+		work = func(ctx context.Context, id int, i int64) {
+			runWebSocket(ctx, i, infoMsgsCh, id, reqMadeOnSecCh, failsOnSecCh, durationCh, bytesPerSecCh)
+		}
+	}
+	requesters = workerpool.MakeNew(work, INFO)
+	// between the requests (or sessions, or connections), the workers
+	// aren't in flight while they're thinking
+	requesters.Think = thinkTime.Next
+	// so the --feed :unique rows don't run out as workers come and go
+	requesters.Done = templates.Release
+
+	// take over the screen (or not, for --ui=text)
+	var ui frontEnd
+	if *uiMode == "text" {
+		ui = startTextUI(infoMsgsCh, exitCh, changeNumRequestersCh, resetStatsCh)
+	} else {
+		ui = startCursesUI(infoMsgsCh, exitCh, changeNumRequestersCh, resetStatsCh, resizeCh, viewCh)
+	}
+	reporters = append([]stats.Reporter{ui}, reporters...)
 
 	// clean up the screen before we die
 	defer func() {
 		if err := recover(); err != nil {
-			resetScreen()
+			ui.Close()
 			fmt.Fprintf(os.Stderr, "exiting from error: %s \n", err)
 			ERROR.Println("exiting from error: ", err)
 			exitStatus = 1
@@ -128,38 +474,35 @@ func realMain() (exitStatus int) {
 		}
 	}()
 
-	// draw the stuff on the screen
-	msgWin, workerCountWin, durWin, reqSecWin, barsWin, scaleWin, maxWin := drawDisplay(stdscr)
-
-	// create our various channels
-	infoMsgsCh := make(chan ncursesMsg)
-	exitCh := make(chan int)
-	changeNumRequestersCh := make(chan interface{})
-	changeNumRequestersListenerCh := make(chan interface{})
-	reqMadeOnSecCh := make(chan interface{})
-	reqMadeOnSecListenerCh := make(chan interface{})
-	reqMadeOnSecSlaveListenerCh := make(chan interface{})
-	failsOnSecCh := make(chan int)
-	durationCh := make(chan int64)
-	durationDisplayCh := make(chan string)
-	reqSecDisplayCh := make(chan string)
-	bytesPerSecCh := make(chan bytesPerSecMsg)
-	bytesPerSecDisplayCh := make(chan string)
-	barsToDrawCh := make(chan currentBars)
-
 	// start all the worker goroutines
-	go windowRunloop(infoMsgsCh, exitCh, changeNumRequestersCh, msgWin)
-	go barsController(reqMadeOnSecListenerCh, failsOnSecCh, barsToDrawCh, reqSecDisplayCh)
-	go requesterController(infoMsgsCh, changeNumRequestersListenerCh, reqMadeOnSecCh, failsOnSecCh, durationCh, bytesPerSecCh, *testUrl, *introduceRandomFails)
-	go durationWinController(durationCh, durationDisplayCh)
-	go bytesPerSecController(bytesPerSecCh, bytesPerSecDisplayCh)
+	go requesterController(changeNumRequestersListenerCh, requesters)
 
 	numRequestersBcaster := bcast.MakeNew(changeNumRequestersCh, INFO)
 	numRequestersBcaster.Join(changeNumRequestersListenerCh)
+	numRequestersBcaster.Join(numRequestersStatsCh)
 
 	reqMadeOnSecBcaster := bcast.MakeNew(reqMadeOnSecCh, INFO)
 	reqMadeOnSecBcaster.Join(reqMadeOnSecListenerCh)
 
+	failsOnSecBcaster := bcast.MakeNew(failsOnSecCh, INFO)
+	failsOnSecBcaster.Join(failsOnSecListenerCh)
+
+	durationBcaster := bcast.MakeNew(durationCh, INFO)
+	durationBcaster.Join(durationListenerCh)
+
+	bytesPerSecBcaster := bcast.MakeNew(bytesPerSecCh, INFO)
+	bytesPerSecBcaster.Join(bytesPerSecListenerCh)
+
+	collector := stats.MakeNew(int(history.Seconds()), INFO)
+	go collector.Run(stats.Inputs{
+		ReqMadeOnSec:  reqMadeOnSecListenerCh,
+		Fails:         failsOnSecListenerCh,
+		Durations:     durationListenerCh,
+		Bytes:         bytesPerSecListenerCh,
+		NumRequesters: numRequestersStatsCh,
+		Reset:         resetStatsCh,
+	}, snapshotCh)
+
 	if *listen > 0 {
 		port := *listen
 		// we don't want to join until we start the listener
@@ -171,308 +514,240 @@ func realMain() (exitStatus int) {
 		connectToSlaves(slaveList, numRequestersBcaster, reqMadeOnSecCh)
 	}
 
-	currentScale := int64(1)
+	// the dashboard and /metrics can share a port, so collect up the
+	// handlers first and start the listeners after
+	muxes := make(map[int]*http.ServeMux)
+
+	if *webPort > 0 {
+		dashboard := webui.MakeNew(int(history.Seconds()), INFO)
+		dashboard.Register(muxForPort(muxes, *webPort))
+		reporters = append(reporters, dashboard)
+	}
+
+	if *metricsPort > 0 {
+		registry := metrics.MakeNew()
+		muxForPort(muxes, *metricsPort).Handle("/metrics", registry)
+
+		numRequestersMetricsCh := make(chan interface{})
+		numRequestersBcaster.Join(numRequestersMetricsCh)
+		failsMetricsCh := make(chan interface{})
+		failsOnSecBcaster.Join(failsMetricsCh)
+		durationMetricsCh := make(chan interface{})
+		durationBcaster.Join(durationMetricsCh)
+		bytesMetricsCh := make(chan interface{})
+		bytesPerSecBcaster.Join(bytesMetricsCh)
+
+		go metricsController(newLoadMetrics(registry, *testUrl, *nodeName),
+			numRequestersMetricsCh, failsMetricsCh, durationMetricsCh, bytesMetricsCh)
+	}
+
+	for port, mux := range muxes {
+		go serveHTTP(port, mux)
+	}
+
+	var search *capacity.Search
+	if *searchMode {
+		searchTargetsCh := make(chan int)
+		searchMsgsCh := make(chan string)
+		search = capacity.MakeNew(capacity.Config{
+			Start:       *searchStart,
+			Step:        *searchStep,
+			Max:         *searchMax,
+			HoldSecs:    int(searchHold.Seconds()),
+			MaxP99Ms:    float64(*sloP99 / time.Millisecond),
+			MaxErrorPct: *sloErrors,
+		}, searchTargetsCh, searchMsgsCh, INFO)
+		reporters = append(reporters, search)
+		go autoWorkersController(infoMsgsCh, changeNumRequestersCh, searchTargetsCh, searchMsgsCh)
+		go search.Run()
+	}
+
+	if *targetP95 > 0 {
+		adaptTargetsCh := make(chan int)
+		adaptMsgsCh := make(chan string)
+		controller := adaptive.MakeNew(adaptive.Config{
+			TargetP95Ms:  float64(*targetP95 / time.Millisecond),
+			MaxErrorPct:  *sloErrors,
+			Min:          1,
+			Max:          *adaptMax,
+			IntervalSecs: int(adaptEvery.Seconds()),
+			Paused:       requesters.Paused,
+		}, adaptTargetsCh, adaptMsgsCh, INFO)
+		reporters = append(reporters, controller)
+		go autoWorkersController(infoMsgsCh, changeNumRequestersCh, adaptTargetsCh, adaptMsgsCh)
+		go controller.Run()
+	}
 
-	// This is the main loop controlling the ncurses display. Since ncurses
-	// wasn't designed with concurrency in mind, only one goroutine should
-	// write to a window, so I'm putting all the window writing in here.
+	// This is the main loop controlling the display. Since ncurses wasn't
+	// designed with concurrency in mind, only one goroutine should write
+	// to a window, so I'm putting all the window writing in here.
+	exiting := false
 main:
 	for {
 		select {
 		case msg := <-infoMsgsCh:
-			updateMsgWin(msg, msgWin, workerCountWin)
-		case msg := <-durationDisplayCh:
-			// that %7s should really be determined from durWidth
-			durWin.MovePrint(1, 1, fmt.Sprintf("%11s", msg))
-			durWin.NoutRefresh()
-		case msg := <-reqSecDisplayCh:
-			reqSecWin.MovePrint(1, 1, fmt.Sprintf("%14s", msg))
-			reqSecWin.NoutRefresh()
-		case msg := <-barsToDrawCh:
-			currentScale = calculateScale(msg.max)
-			// 25 is the number of rows in the window, s/b dynamic or defined elsewhere
-			maxWin.MovePrint(1, 1, fmt.Sprintf("%5d", msg.max))
-			maxWin.NoutRefresh()
-			scaleWin.MovePrint(1, 1, fmt.Sprintf("%5d", currentScale))
-			scaleWin.NoutRefresh()
-			updateBarsWin(msg, barsWin, *colors, currentScale)
-		case msg := <-bytesPerSecDisplayCh:
-			//msgAsFloat64, err := strconv.ParseFloat(msg, 64)
-			//if err != nil {
-			//    panic(fmt.Sprintf("converting %s failed: %v", msg, err))
-			//}
-			//if msgAsFloat64 > 0 {
-			if msg != "      0.00" {
-				INFO.Println("bytes/sec for each second: ", msg)
+			ui.ShowMsg(msg)
+		case snapshot := <-snapshotCh:
+			snapshot.InFlight = requesters.InFlight()
+			snapshot.Faults = faultInjector.Counts()
+			snapshot.TLS = httpClient.TLSStats()
+			snapshot.Backends = httpClient.BackendStats()
+			snapshot.Proxy = httpClient.ProxyStats()
+			snapshot.H2 = httpClient.H2Stats()
+			snapshot.WS = wsCounts.Stats()
+			for _, reporter := range reporters {
+				reporter.Report(snapshot)
 			}
+			if snapshot.BytesPerSec > 0 {
+				INFO.Println("bytes/sec for each second: ", fmt.Sprintf("%10.2f", snapshot.BytesPerSec))
+			}
+		case size := <-resizeCh:
+			ui.Resize(size)
+		case v := <-viewCh:
+			ui.ChangeView(v)
 		case exitStatus = <-exitCh:
+			if exiting {
+				// they're impatient
+				break main
+			}
+			// the requesters are still sending results while they
+			// drain, so we have to keep going round until they're done
+			exiting = true
+			inFlight := requesters.InFlight()
+			INFO.Println("waiting for ", inFlight, " requests in flight")
+			ui.ShowMsg(ncursesMsg{fmt.Sprintf("waiting for %d requests in flight, q again to give up", inFlight), -1, MSG_TYPE_INFO, ""})
+			go func() {
+				drainedCh <- requesters.Drain(*drainTimeout)
+			}()
+		case clean := <-drainedCh:
+			if !clean {
+				INFO.Println("had to abort some requests on the way out")
+			}
 			break main
 		}
 
-		gc.Update()
+		ui.Update()
 	}
 
-	msgWin.Delete()
-	gc.End()
+	ui.Close()
+	if search != nil {
+		if best, done := search.Result(); done {
+			fmt.Printf("capacity search: best within the SLO was %s\n", best)
+		} else {
+			fmt.Println("capacity search didn't finish")
+		}
+	}
+	printTLSSummary(httpClient.TLSStats())
+	printBackendSummary(httpClient.BackendStats())
+	printProxySummary(httpClient.ProxyStats())
+	printH2Summary(httpClient.H2Stats())
+	printWSSummary(wsCounts.Stats())
 	INFO.Println("exiting with status ", exitStatus)
 	return exitStatus
 }
 
-func calculateScale(max int64) int64 {
-
-	var rc int64
-	if max == 0 {
-		rc = 1
-	} else {
-		// 25 is the number of rows in the window, s/b dynamic or defined elsewhere
-		rc = int64(max/25) + 1
+func muxForPort(muxes map[int]*http.ServeMux, port int) *http.ServeMux {
+	if _, ok := muxes[port]; !ok {
+		muxes[port] = http.NewServeMux()
 	}
-	return rc
+	return muxes[port]
 }
 
-func initializeNcurses() (stdscr *gc.Window, colors *colorsDefined, resetScreen resetScreenFn) {
-
-	stdscr, err := gc.Init()
+func serveHTTP(port int, mux *http.ServeMux) {
+	INFO.Printf("serving http on port %d", port)
+	err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux)
 	if err != nil {
-		log.Fatal(err)
-	}
-	defer gc.End()
-	resetScreen = func() {
-		gc.End()
+		panic(fmt.Sprintf("http listener on port %d failed: %v", port, err))
 	}
+}
 
-	// Turn off character echo, hide the cursor and disable input buffering
-	gc.Echo(false)
-	gc.CBreak(true)
-	gc.StartColor()
-
-	// initialize colors
-	whiteOnBlack := int16(1)
-	gc.InitPair(whiteOnBlack, gc.C_WHITE, gc.C_BLACK)
-	greenOnBlack := int16(2)
-	gc.InitPair(greenOnBlack, gc.C_GREEN, gc.C_BLACK)
-	redOnBlack := int16(3)
-	gc.InitPair(redOnBlack, gc.C_RED, gc.C_BLACK)
-
-	// Set the cursor visibility.
-	// Options are: 0 (invisible/hidden), 1 (normal) and 2 (extra-visible)
-	gc.Cursor(0)
-
-	colors = &colorsDefined{whiteOnBlack, greenOnBlack, redOnBlack}
+func dialSinks(specs []string) ([]sinks.Sink, error) {
+	prefix := *sinkPrefix
+	if prefix != "" {
+		prefix += "." + sinks.PathSafe(*nodeName)
+	}
+	tags := map[string]string{"node": *nodeName, "url": *testUrl}
+
+	metricSinks := make([]sinks.Sink, 0, len(specs))
+	for _, spec := range specs {
+		var sink sinks.Sink
+		var err error
+		if strings.HasPrefix(spec, "influx") {
+			sink, err = sinks.Dial(spec, *sinkPrefix, tags)
+		} else {
+			sink, err = sinks.Dial(spec, prefix, nil)
+		}
+		if err != nil {
+			return nil, err
+		}
+		INFO.Println("pushing stats to " + spec)
+		metricSinks = append(metricSinks, sink)
+	}
+	return metricSinks, nil
+}
 
-	return
+// --report text:path or json:path
+func openReporters(specs []string) ([]stats.Reporter, error) {
+	reporters := make([]stats.Reporter, 0, len(specs))
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("'%s' s/b text:path or json:path", spec)
+		}
+		w, err := os.OpenFile(parts[1], os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		if err != nil {
+			return nil, err
+		}
+		switch parts[0] {
+		case "text":
+			reporters = append(reporters, stats.NewTextReporter(w))
+		case "json":
+			reporters = append(reporters, stats.NewJSONReporter(w))
+		default:
+			return nil, fmt.Errorf("unknown report format '%s', want text or json", parts[0])
+		}
+	}
+	return reporters, nil
 }
 
-func drawDisplay(
-	stdscr *gc.Window,
-) (
-	msgWin *gc.Window,
-	workerCountWin *gc.Window,
-	durWin *gc.Window,
-	reqSecWin *gc.Window,
-	barsWin *gc.Window,
-	scaleWin *gc.Window,
-	maxWin *gc.Window,
-) {
+// sinkReporter hands the stats off to sinkController, so a slow tcp sink
+// doesn't hold up the display
+type sinkReporter struct {
+	sinkStatsCh chan []sinks.Stat
+}
 
-	// print startup message
-	stdscr.Print("Press 'q' to exit")
-	stdscr.NoutRefresh()
-
-	// Create message window
-	// and enable the use of the
-	// keypad on it so the arrow keys are available
-	msgHeight, msgWidth := 5, 40
-	msgY, msgX := 1, 0
-	msgWin = createWindow(msgHeight, msgWidth, msgY, msgX)
-	msgWin.Keypad(true)
-	msgWin.Box(0, 0)
-	msgWin.NoutRefresh()
-
-	// Create the counter window, showing how many goroutines are active
-	ctrHeight, ctrWidth := 3, 7
-	ctrY := 2
-	ctrX := msgWidth + 1
-	stdscr.MovePrint(1, ctrX+1, "thrds")
-	stdscr.NoutRefresh()
-	workerCountWin = createWindow(ctrHeight, ctrWidth, ctrY, ctrX)
-	workerCountWin.Box(0, 0)
-	workerCountWin.NoutRefresh()
-
-	// Create the avg duration window, showing 5 second moving average
-	durHeight, durWidth := 4, 14
-	durY := 2
-	durX := ctrX + ctrWidth + 1
-	stdscr.MovePrint(1, durX+1, "duration ms")
-	stdscr.NoutRefresh()
-	durWin = createWindow(durHeight, durWidth, durY, durX)
-	durWin.Box(0, 0)
-	durWin.NoutRefresh()
-
-	// Create the requests/sec window,
-	reqSecHeight, reqSecWidth := 3, 16
-	reqSecY := 2
-	reqSecX := durX + durWidth + 1
-	stdscr.MovePrint(1, reqSecX+1, "req/s 1/5/60")
-	stdscr.NoutRefresh()
-	reqSecWin = createWindow(reqSecHeight, reqSecWidth, reqSecY, reqSecX)
-	reqSecWin.Box(0, 0)
-	reqSecWin.NoutRefresh()
-
-	// Create the bars window, showing the moving display of bars
-	secondsPerMinute := 60
-	barsWidth := secondsPerMinute + 3 // we wrap after a minute
-	barsHeight := 25                  // need to size this dynamically, TBD
-	barsY := msgHeight + 1
-	barsX := 9 // leave space for scale window
-	barsWin = createWindow(barsHeight, barsWidth, barsY, barsX)
-	barsWin.Box(0, 0)
-	barsWin.NoutRefresh()
-
-	// Max window, showing the max seen over the last 60 seconds
-	maxWidth := 7
-	maxHeight := 3
-	maxY := barsY + barsHeight - 8
-	maxX := 1
-	stdscr.MovePrint(maxY, 1, "max:")
-	stdscr.NoutRefresh()
-	maxY += 1
-	maxWin = createWindow(maxHeight, maxWidth, maxY, maxX)
-	maxWin.Box(0, 0)
-	maxWin.NoutRefresh()
-
-	// Scale window, showing our current scaling factor for the bars display
-	scaleWidth := 7
-	scaleHeight := 3
-	scaleY := barsY + barsHeight - 4
-	scaleX := 1
-	stdscr.MovePrint(scaleY, 1, "scale:")
-	stdscr.NoutRefresh()
-	scaleY += 1
-	scaleWin = createWindow(scaleHeight, scaleWidth, scaleY, scaleX)
-	scaleWin.Box(0, 0)
-	scaleWin.MovePrint(1, 1, fmt.Sprintf("%5s", "1"))
-	scaleWin.NoutRefresh()
-
-	// Update will flush only the characters which have changed between the
-	// physical screen and the virtual screen, minimizing the number of
-	// characters which must be sent
-	gc.Update()
-
-	return
-}
-
-func createWindow(height int, width int, y int, x int) (win *gc.Window) {
-	win, err := gc.NewWindow(height, width, y, x)
-	if err != nil {
-		log.Fatal(err)
-	}
-	return
+func newSinkReporter(metricSinks []sinks.Sink) *sinkReporter {
+	r := &sinkReporter{make(chan []sinks.Stat, 10)}
+	go sinkController(metricSinks, r.sinkStatsCh)
+	return r
 }
 
-func updateMsgWin(msg ncursesMsg, msgWin *gc.Window, workerCountWin *gc.Window) {
-	var row int
-	if msg.msgType == MSG_TYPE_RESULT {
-		row = 1
-	} else if msg.msgType == MSG_TYPE_INFO {
-		row = 2
-	} else {
-		row = 3
-	}
-	msgWin.MovePrint(row, 1, fmt.Sprintf("%-40s", msg.msgStr))
-	msgWin.Box(0, 0)
-	msgWin.NoutRefresh()
-	if msg.currentCount >= 0 {
-		workerCountWin.MovePrint(1, 1, fmt.Sprintf("%5d", msg.currentCount))
-		workerCountWin.NoutRefresh()
-	}
-}
-func updateBarsWin(msg currentBars, barsWin *gc.Window, colors colorsDefined, scale int64) {
-
-	whiteOnBlack := colors.whiteOnBlack
-	redOnBlack := colors.redOnBlack
-	greenOnBlack := colors.greenOnBlack
-	barsWin.Erase()
-	barsWin.Box(0, 0)
-	edibleCopy := make([]int64, len(msg.cols))
-	copy(edibleCopy, msg.cols)
-	barsHeight, barsWidth := barsWin.MaxYX()
-	startI := len(edibleCopy) - barsWidth
-	if startI < 0 {
-		startI = 0
-	}
-	currentSec := time.Now().Second()
-	prevSec := currentSec - 1
-	if prevSec < 0 {
-		prevSec = 59
-	}
-	for row := 0; row < barsHeight-2; row++ {
-		for col := range edibleCopy[startI:len(edibleCopy)] {
-			if edibleCopy[col]/scale > 0 {
-				turnOffColor := int16(0)
-				currChar := "="
-				// row is an int--32-bit, right?
-				if shouldShowFail(msg.failCols[col], scale, row) {
-					barsWin.ColorOff(whiteOnBlack)
-					barsWin.ColorOn(redOnBlack)
-					currChar = "x"
-					turnOffColor = redOnBlack
-
-				} else if col == currentSec ||
-					col == currentSec-1 {
-					// current second is still in progress, so make the previous second
-					// green too--not precisely correct, but close enough here
-					barsWin.ColorOff(whiteOnBlack)
-					barsWin.ColorOn(greenOnBlack)
-					turnOffColor = greenOnBlack
-				}
-
-				barsWin.MovePrint(barsHeight-2-row, col+1, currChar)
-
-				if turnOffColor != 0 {
-					barsWin.ColorOff(turnOffColor)
-					barsWin.ColorOn(whiteOnBlack)
-				}
-
-				edibleCopy[col] = edibleCopy[col] - scale
-			}
-		}
+func (r *sinkReporter) Report(s stats.Snapshot) {
+	select {
+	case r.sinkStatsCh <- sinkStats(s):
+	default:
+		INFO.Println("metric sinks are falling behind, dropping a second")
 	}
-	barsWin.NoutRefresh()
 }
 
-// Called from updateBarsWin
-// The scale factor would result in a fractional value if there's
-// only one fail this second--we always want to show a fail marker
-// if there are *any* fails, otherwise they become invisible
-func shouldShowFail(numFailsThisSec int64, scale int64, rowNum int) bool {
-	var rc bool
-	if numFailsThisSec/scale > int64(rowNum) ||
-		rowNum == 0 && numFailsThisSec > 0 {
-		rc = true
-	} else {
-		rc = false
+// the same numbers the display shows for the last second
+func sinkStats(s stats.Snapshot) []sinks.Stat {
+	return []sinks.Stat{
+		{Name: "reqs", Value: float64(s.ReqSec)},
+		{Name: "fails", Value: float64(s.Fails)},
+		{Name: "latency_ms", Value: s.LatencyMs},
+		{Name: "bytes_per_sec", Value: s.BytesPerSec},
+		{Name: "workers", Value: float64(s.Workers)},
 	}
-	return rc
 }
 
-func windowRunloop(
-	infoMsgsCh chan<- ncursesMsg,
-	exitCh chan<- int,
-	changeNumRequestersCh chan<- interface{},
-	win *gc.Window,
-) {
-	threadCount := 0
-	for {
-		switch win.GetChar() {
-		case 'q':
-			exitCh <- 0
-		case 's', '+', '=', gc.KEY_UP:
-			threadCount++
-			increaseThreads(infoMsgsCh, changeNumRequestersCh, win, threadCount)
-		case '-', gc.KEY_DOWN:
-			threadCount--
-			decreaseThreads(infoMsgsCh, changeNumRequestersCh, win, threadCount)
+func sinkController(metricSinks []sinks.Sink, sinkStatsCh <-chan []sinks.Stat) {
+	for sinkStats := range sinkStatsCh {
+		now := time.Now()
+		for _, sink := range metricSinks {
+			if err := sink.Send(now, sinkStats); err != nil {
+				ERROR.Println("sending stats to sink failed: ", err)
+			}
 		}
 	}
 }
@@ -480,271 +755,328 @@ func windowRunloop(
 func increaseThreads(
 	infoMsgsCh chan<- ncursesMsg,
 	changeNumRequestersCh chan<- interface{},
-	win *gc.Window,
-	threadCount int,
 ) {
+	threadCount := int(atomic.AddInt32(&controls.workers, 1))
 	INFO.Println("increasing threads to ", threadCount)
-	infoMsgsCh <- ncursesMsg{"increasing threads", threadCount, MSG_TYPE_INFO}
+	infoMsgsCh <- ncursesMsg{"increasing threads", threadCount, MSG_TYPE_INFO, ""}
 	changeNumRequestersCh <- 1
 }
 
 func decreaseThreads(
 	infoMsgsCh chan<- ncursesMsg,
 	changeNumRequestersCh chan<- interface{},
-	win *gc.Window,
-	threadCount int,
 ) {
+	threadCount := int(atomic.AddInt32(&controls.workers, -1))
+	if threadCount < 0 {
+		// nothing to shut down
+		atomic.AddInt32(&controls.workers, 1)
+		return
+	}
 	INFO.Println("decreasing threads to ", threadCount)
-	infoMsgsCh <- ncursesMsg{"decreasing threads", threadCount, MSG_TYPE_INFO}
+	infoMsgsCh <- ncursesMsg{"decreasing threads", threadCount, MSG_TYPE_INFO, ""}
 	changeNumRequestersCh <- -1
 }
 
-func requesterController(
+// does what the capacity search or the adaptive controller asks for, same
+// as if you'd typed it
+func autoWorkersController(
 	infoMsgsCh chan<- ncursesMsg,
-	changeNumRequestersListenerCh <-chan interface{},
-	reqMadeOnSecCh chan<- interface{},
-	failsOnSecCh chan<- int,
-	durationCh chan<- int64,
-	bytesPerSecCh chan<- bytesPerSecMsg,
-	testUrl string,
-	introduceRandomFails int,
+	changeNumRequestersCh chan<- interface{},
+	targetsCh <-chan int,
+	msgsCh <-chan string,
 ) {
-
-	//var chans = []chan int
-	// this creates a slice associated with an underlying array
-	chans := make([]chan int, 0)
-
 	for {
 		select {
-		case upOrDown := <-changeNumRequestersListenerCh:
-			if upOrDown == 1 {
-				shutdownChan := make(chan int)
-				chans = append(chans, shutdownChan)
-				chanId := len(chans) - 1
-				go requester(infoMsgsCh, shutdownChan, chanId, reqMadeOnSecCh, failsOnSecCh, durationCh, bytesPerSecCh, testUrl, introduceRandomFails)
-			} else if upOrDown == -1 && len(chans) > 0 {
-				//send shutdown message
-				chans[len(chans)-1] <- 1
-				// throw away that channel
-				chans = chans[0 : len(chans)-1]
-			} else {
-				INFO.Println("ignoring decrease--there aren't any channels")
-			}
+		case target := <-targetsCh:
+			changeThreadsTo(infoMsgsCh, changeNumRequestersCh, target)
+		case msg := <-msgsCh:
+			infoMsgsCh <- ncursesMsg{msg, -1, MSG_TYPE_INFO, ""}
 		}
 	}
 }
 
-func requester(
-	infoMsgsCh chan<- ncursesMsg,
-	shutdownChan <-chan int,
-	id int,
-	reqMadeOnSecCh chan<- interface{},
-	failsOnSecCh chan<- int,
-	durationCh chan<- int64,
-	bytesPerSecCh chan<- bytesPerSecMsg,
-	testUrl string,
-	introduceRandomFails int,
+func requesterController(
+	changeNumRequestersListenerCh <-chan interface{},
+	requesters *workerpool.Pool,
 ) {
-
-	var i int64 = 0
-	var shutdownNow bool = false
-
-	for {
-		select {
-		case _ = <-shutdownChan:
-			INFO.Println("shutting down #", id)
-			shutdownNow = true
-		default:
-			i++
-			makeRequest(i, infoMsgsCh, shutdownChan, id, reqMadeOnSecCh, failsOnSecCh,
-				durationCh, bytesPerSecCh, testUrl, introduceRandomFails)
-		}
-		if shutdownNow {
-			return
+	for upOrDown := range changeNumRequestersListenerCh {
+		if upOrDown == 1 {
+			if id, ok := requesters.Add(); ok {
+				INFO.Println("starting #", id)
+			}
+		} else if upOrDown == -1 {
+			if id, ok := requesters.Remove(); ok {
+				INFO.Println("shutting down #", id)
+			} else {
+				INFO.Println("ignoring decrease--there aren't any requesters")
+			}
 		}
 	}
 }
 
 func makeRequest(
+	ctx context.Context,
 	i int64,
 	infoMsgsCh chan<- ncursesMsg,
-	shutdownChan <-chan int,
 	id int,
 	reqMadeOnSecCh chan<- interface{},
-	failsOnSecCh chan<- int,
-	durationCh chan<- int64,
-	bytesPerSecCh chan<- bytesPerSecMsg,
-	testUrl string,
-	introduceRandomFails int,
+	failsOnSecCh chan<- interface{},
+	durationCh chan<- interface{},
+	bytesPerSecCh chan<- interface{},
+	reqTmpl *requestTemplate,
 ) {
-	thisUrl := testUrl
-	if introduceRandomFails > 0 && rand.Intn(10) < introduceRandomFails {
-		thisUrlStruct, _ := url.Parse(thisUrl)
-		thisUrlStruct.Path = "-artificial-random-failure-" + thisUrlStruct.Path
-		thisUrl = thisUrlStruct.String()
+	hitId := hitIds.ID(id, strconv.FormatInt(i, 10))
+	// fill in the placeholders, the messages use the --url as it was given
+	// so the event log can fold them together
+	env := tmpl.NewEnv(id, map[string]string{
+		"worker":    strconv.Itoa(id),
+		"iteration": strconv.FormatInt(i, 10),
+	})
+	reqUrl := reqTmpl.url.String()
+	thisUrl, err := reqTmpl.url.Expand(env)
+	var reqBody string
+	if err == nil {
+		reqBody, err = reqTmpl.body.Expand(env)
+	}
+	header := make(http.Header)
+	for _, h := range reqTmpl.headers {
+		if err != nil {
+			break
+		}
+		var value string
+		if value, err = h.value.Expand(env); err == nil {
+			header.Set(h.name, value)
+		}
+	}
+	if err != nil {
+		ERROR.Println("can't fill in the request: ", hitId, " ", err)
+		infoMsgsCh <- ncursesMsg{reqTmpl.method + " " + reqUrl + ": " + err.Error(), -1, MSG_TYPE_RESULT, ""}
+		failsOnSecCh <- stats.Fail{Second: time.Now().Second(), Class: "script"}
+		return
 	}
-	hitId := strconv.FormatInt(int64(id), 10) + ":" + strconv.FormatInt(i, 10)
 
-	// make the request and time it
-	t0 := time.Now()
-	if strings.Index(thisUrl, "?") > 0 {
-		thisUrl = thisUrl + "&hitid=" + hitId
-	} else {
-		thisUrl = thisUrl + "?hitid=" + hitId
+	req, err := http.NewRequest(reqTmpl.method, thisUrl, strings.NewReader(reqBody))
+	var brokePath bool
+	if err == nil {
+		req.Header = header
+		hitIds.Tag(req, hitId)
+		switch fault := faultInjector.Pick(); fault {
+		case "":
+		case faults.BadPath:
+			faults.BreakPath(req.URL)
+			brokePath = true
+			reqUrl = "(" + fault + ") " + reqUrl
+		default:
+			injectFault(ctx, fault, req, hitId)
+			return
+		}
 	}
 
-	resp, err := http.Get(thisUrl) // TBD make that appending conditional
-	t1 := time.Now()
+	// make the request and time it, leaving out any time the --proxy took
+	// to set up a connection, that's counted separately
+	reqCtx, timing := httpclient.WithTiming(ctx)
+	t0 := time.Now()
+	var resp *http.Response
+	if err == nil {
+		resp, err = sharedClient.Do(req.WithContext(reqCtx))
+	}
+	t1 := time.Now().Add(-timing.ProxyConnect())
 	nowSec := time.Now().Second()
+	if err != nil && ctx.Err() != nil {
+		// we gave up on it on the way out, not the server's fault
+		INFO.Println("aborted request ", hitId)
+		return
+	}
+	if brokePath {
+		// it went out, whatever the server made of it
+		faultInjector.Count(faults.BadPath)
+	}
 	if err != nil {
-		ERROR.Println("http get failed: ", err)
-		infoMsgsCh <- ncursesMsg{"request fail " + hitId, -1, MSG_TYPE_RESULT}
-		failsOnSecCh <- nowSec
+		ERROR.Println("request failed: ", hitId, " ", err)
+		infoMsgsCh <- ncursesMsg{requestErrorText(reqTmpl.method, reqUrl, err), -1, MSG_TYPE_RESULT, hitId}
+		failsOnSecCh <- stats.Fail{Second: nowSec, Class: failureClass(nil, err)}
 		return
 	}
 	resp.Body.Close() // this only works if ! err
 
+	reportResponse(reqMadeOnSecCh, durationCh, bytesPerSecCh, nowSec, t1.Sub(t0), resp.ContentLength)
+	if resp.StatusCode == 200 {
+		TRACE.Println(id, "/", i, " fetch ok ")
+		// TMI! infoMsgsCh <- ncursesMsg{"request ok " + hitId, -1, MSG_TYPE_RESULT, hitId}
+	} else {
+		ERROR.Println("request failed: ", hitId, " ", resp.Status)
+		infoMsgsCh <- ncursesMsg{reqTmpl.method + " " + reqUrl + ": " + resp.Status, -1, MSG_TYPE_RESULT, hitId}
+		failsOnSecCh <- stats.Fail{Second: nowSec, Class: failureClass(resp, nil), Responded: true}
+	}
+}
+
+// The faults that aren't bad-path only go in the fault counts, not the
+// request stats, what the server did about them is in the log
+func injectFault(ctx context.Context, fault string, req *http.Request, hitId string) {
+	outcome, err := faultInjector.Do(ctx, fault, req, sharedClient)
+	if ctx.Err() != nil {
+		INFO.Println("aborted request ", hitId)
+		return
+	}
+	if err != nil {
+		INFO.Println("fault ", fault, " ", hitId, " didn't happen: ", err)
+		return
+	}
+	faultInjector.Count(fault)
+	INFO.Println("fault ", fault, " ", hitId, ": ", outcome)
+}
+
+// the stats every response counts towards, whatever its status
+func reportResponse(
+	reqMadeOnSecCh chan<- interface{},
+	durationCh chan<- interface{},
+	bytesPerSecCh chan<- interface{},
+	nowSec int,
+	elapsed time.Duration,
+	bytes int64,
+) {
 	// report the duration
-	duration := int64(t1.Sub(t0) / time.Millisecond)
+	duration := int64(elapsed / time.Millisecond)
 	durationCh <- duration
 
 	// report that we made a request this second
 	reqMadeOnSecCh <- nowSec
 
 	// report on the number of bytes
-	bytesPerSecCh <- bytesPerSecMsg{
-		bytes:         resp.ContentLength,
-		duration:      time.Duration(duration),
-		receivedOnSec: nowSec,
-	}
-	if resp.StatusCode == 200 {
-		TRACE.Println(id, "/", i, " fetch ok ")
-		// TMI! infoMsgsCh <- ncursesMsg{"request ok " + hitId, -1, MSG_TYPE_RESULT}
-	} else {
-		ERROR.Println("http get failed: ", resp.Status)
-		infoMsgsCh <- ncursesMsg{"request fail " + hitId, -1, MSG_TYPE_RESULT}
-		failsOnSecCh <- nowSec
+	bytesPerSecCh <- stats.Bytes{
+		Bytes:         bytes,
+		DurationMs:    duration,
+		ReceivedOnSec: nowSec,
 	}
-
-	// just for development
-	time.Sleep(10 * time.Millisecond)
-
 }
 
-// This sends messages to both the barsToDrawCh and the durationDisplayCh--
-// so should I rename this method?
-func barsController(
-	reqMadeOnSecListenerCh <-chan interface{},
-	failsOnSecCh <-chan int,
-	barsToDrawCh chan<- currentBars,
-	reqSecDisplayCh chan<- string,
+// one pass through the --script as a brand new user, each step counts as
+// a request. --random-fails doesn't apply, the script can ask for bad urls
+// itself.
+func runSession(
+	ctx context.Context,
+	i int64,
+	infoMsgsCh chan<- ncursesMsg,
+	id int,
+	reqMadeOnSecCh chan<- interface{},
+	failsOnSecCh chan<- interface{},
+	durationCh chan<- interface{},
+	bytesPerSecCh chan<- interface{},
 ) {
-	requestsForSecond := rb.MakeNew(INFO) // one column for each clock second
-	failsForSecond := rb.MakeNew(INFO)    // one column for each clock second
-
-	secsSeen := 0
-
-	timeToRedraw := make(chan bool)
-	go func(timeToRedraw chan bool) {
-		for {
-			time.Sleep(1000 * time.Millisecond)
-			timeToRedraw <- true
-			if secsSeen <= 60 {
-				secsSeen++
-			}
+	user := userScript.NewUser(id, map[string]string{"iteration": strconv.FormatInt(i, 10)})
+	user.Tag = func(req *http.Request, step int) string {
+		hitId := hitIds.ID(id, strconv.FormatInt(i, 10)+"."+strconv.Itoa(step))
+		hitIds.Tag(req, hitId)
+		return hitId
+	}
+	report := func(r session.Result) {
+		nowSec := time.Now().Second()
+		if r.Err != nil {
+			ERROR.Printf("session step failed: %s %s %s: %v", r.ID, r.Step, r.URL, r.Err)
+			infoMsgsCh <- ncursesMsg{r.Step + ": " + requestErrorText(r.Method, r.Pattern, r.Err), -1, MSG_TYPE_RESULT, r.ID}
+			failsOnSecCh <- stats.Fail{Second: nowSec, Class: failureClass(nil, r.Err)}
+			return
 		}
-	}(timeToRedraw)
-
-	for {
-		select {
-		case msg := <-reqMadeOnSecListenerCh:
-			second := msg.(int)
-			requestsForSecond.IncrementAt(second)
-		case second := <-failsOnSecCh:
-			failsForSecond.IncrementAt(second)
-		case <-timeToRedraw:
-			barsToDrawCh <- currentBars{
-				requestsForSecond.GetArray(),
-				failsForSecond.GetArray(),
-				requestsForSecond.GetMax(),
+		if r.Resp != nil {
+			reportResponse(reqMadeOnSecCh, durationCh, bytesPerSecCh, nowSec, r.Duration, r.Bytes)
+		}
+		if r.Failed == "" {
+			TRACE.Println(id, "/", i, " ", r.Step, " ok")
+			return
+		}
+		ERROR.Printf("session step failed: %s %s %s: %s", r.ID, r.Step, r.URL, r.Failed)
+		class, text := "script", r.Step+": "+r.Failed // never got as far as sending it
+		if r.Resp != nil {
+			class = "check" // didn't get the status or the value it wanted
+			if r.Resp.StatusCode >= 400 {
+				class = failureClass(r.Resp, nil)
 			}
-			reqSecDisplayCh <- fmt.Sprintf("%d/%2.2d/%2.2d",
-				requestsForSecond.GetPrevVal(),
-				// won't be accurate for first five secs
-				requestsForSecond.SumPrevN(5)/5,
-				requestsForSecond.SumPrevN(secsSeen)/
-					int64(secsSeen),
-			)
+			text = r.Step + ": " + r.Method + " " + r.Pattern + ": " + r.Failed
 		}
+		infoMsgsCh <- ncursesMsg{text, -1, MSG_TYPE_RESULT, r.ID}
+		failsOnSecCh <- stats.Fail{Second: nowSec, Class: class, Responded: r.Resp != nil}
 	}
+	user.Run(ctx, report, func() { thinktime.Sleep(ctx, thinkTime.Next()) })
 }
 
-func durationWinController(
-	durationCh <-chan int64,
-	durationDisplayCh chan<- string,
-) {
-	totalDurForSecond := rb.MakeNew(INFO) // total durations for each clock second
-	countForSecond := rb.MakeNew(INFO)    // how many received per second
-	lookbackSecs := 5
-	//	secsSeen := 0
-
-	timeToRedraw := make(chan bool)
-	go func(timeToRedraw chan bool) {
-		for {
-			time.Sleep(1000 * time.Millisecond)
-			timeToRedraw <- true
-		}
-	}(timeToRedraw)
-
-	for {
-		select {
-		case dur := <-durationCh:
-			totalDurForSecond.ChangeHeadBy(dur)
-			countForSecond.IncrementHead()
-		case <-timeToRedraw:
-
-			windowDur := totalDurForSecond.SumPrevN(lookbackSecs)
-			windowCount := countForSecond.SumPrevN(lookbackSecs)
+// The event log folds repeats together, so this leaves out the hitid that
+// http.Get puts in its errors
+func requestErrorText(method string, reqUrl string, err error) string {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	return fmt.Sprintf("%s %s: %v", method, reqUrl, err)
+}
 
-			if windowCount > 0 {
-				avgDur := float64(windowDur) / float64(windowCount)
-				durationDisplayCh <- fmt.Sprintf("%11.2f\n (avg last %d)", avgDur, lookbackSecs)
-			} else {
-				durationDisplayCh <- "0"
-			}
+// Buckets failures for the metrics labels: "timeout" and "network" for
+// transport errors, otherwise the status class like "5xx"
+func failureClass(resp *http.Response, err error) string {
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return "timeout"
 		}
+		return "network"
 	}
+	return fmt.Sprintf("%dxx", resp.StatusCode/100)
 }
 
-func bytesPerSecController(bytesPerSecCh <-chan bytesPerSecMsg, bytesPerSecDisplayCh chan<- string) {
-
-	bytesRecdForSecond := rb.MakeNew(INFO)
-	durationForSecond := rb.MakeNew(INFO)
-	lookbackSecs := 5
+type loadMetrics struct {
+	requests *metrics.Counter
+	failures *metrics.CounterVec
+	latency  *metrics.Histogram
+	bytes    *metrics.Counter
+	workers  *metrics.Gauge
+	url      string
+	node     string
+}
 
-	timeToRedraw := make(chan bool)
-	go func(timeToRedraw chan bool) {
-		for {
-			time.Sleep(1000 * time.Millisecond)
-			timeToRedraw <- true
-		}
-	}(timeToRedraw)
+func newLoadMetrics(registry *metrics.Registry, url string, node string) *loadMetrics {
+	m := &loadMetrics{url: url, node: node}
+	m.requests = registry.NewCounterVec("loadtest_requests_total",
+		"HTTP responses received, including non-200s.", "url", "node").WithLabels(url, node)
+	m.failures = registry.NewCounterVec("loadtest_failures_total",
+		"Failed requests by class (network, timeout, 4xx, 5xx...).", "url", "node", "class")
+	m.latency = registry.NewHistogramVec("loadtest_request_duration_seconds",
+		"Time from sending the request to getting the response headers.",
+		metrics.DefaultBuckets, "url", "node").WithLabels(url, node)
+	m.bytes = registry.NewCounterVec("loadtest_response_bytes_total",
+		"Response body bytes, as reported by Content-Length.", "url", "node").WithLabels(url, node)
+	m.workers = registry.NewGaugeVec("loadtest_active_workers",
+		"Number of requester goroutines running.", "url", "node").WithLabels(url, node)
+	return m
+}
 
+// Listens on the same broadcasts as the stats collector. These only
+// count requests made by this process, on a master the slaves' traffic
+// shows up in their own /metrics.
+func metricsController(
+	m *loadMetrics,
+	numRequestersListenerCh <-chan interface{},
+	failsListenerCh <-chan interface{},
+	durationListenerCh <-chan interface{},
+	bytesListenerCh <-chan interface{},
+) {
+	workers := 0
 	for {
 		select {
-		case msg := <-bytesPerSecCh:
-			bytesRecdForSecond.IncrementAtBy(msg.receivedOnSec, msg.bytes)
-			durationForSecond.IncrementAtBy(msg.receivedOnSec, int64(msg.duration))
-		case <-timeToRedraw:
-			windowDur := durationForSecond.SumPrevN(lookbackSecs)
-			windowBytes := bytesRecdForSecond.SumPrevN(lookbackSecs)
-			// divide-by-zero guard
-			if windowDur == 0 {
-				windowDur = 1
+		case msg := <-numRequestersListenerCh:
+			// requesterController ignores a decrease when there's nothing
+			// to shut down, so we do too
+			workers += msg.(int)
+			if workers < 0 {
+				workers = 0
+			}
+			m.workers.Set(float64(workers))
+		case msg := <-failsListenerCh:
+			m.failures.WithLabels(m.url, m.node, msg.(stats.Fail).Class).Inc()
+		case msg := <-durationListenerCh:
+			m.requests.Inc()
+			m.latency.Observe(float64(msg.(int64)) / 1000)
+		case msg := <-bytesListenerCh:
+			if bytes := msg.(stats.Bytes).Bytes; bytes > 0 {
+				m.bytes.Add(float64(bytes))
 			}
-			bytesPerSecDisplayCh <- fmt.Sprintf("%10.2f", float64(windowBytes)/float64(windowDur)*1000)
 		}
-
 	}
 }
 
@@ -817,3 +1149,71 @@ func processMsgFromSlave(msg slave.MsgFromSlave, reqMadeOnSecCh chan<- interface
 43 shift plus
 45 minus
 */
+
+// what the https connections negotiated over the whole run, printed after
+// the screen's been given back
+func printTLSSummary(t *stats.TLSStats) {
+	if t == nil {
+		return
+	}
+	resumedPct := 0.0
+	if t.Handshakes > 0 {
+		resumedPct = float64(t.Resumed) / float64(t.Handshakes) * 100
+	}
+	fmt.Printf("TLS: %d handshakes (%.0f%% resumed), %d failed, avg %.1fms, max %.1fms\n",
+		t.Handshakes, resumedPct, t.Failed, t.HandshakeAvgMs, t.HandshakeMaxMs)
+	for _, dist := range []struct {
+		name   string
+		counts map[string]int64
+	}{{"versions", t.Versions}, {"ciphers", t.Ciphers}} {
+		names := make([]string, 0, len(dist.counts))
+		for name := range dist.counts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("  %-8s %-45s %d\n", dist.name, name, dist.counts[name])
+		}
+	}
+}
+
+// how each --resolve backend did over the whole run
+func printBackendSummary(backends map[string]*stats.BackendStats) {
+	if len(backends) == 0 {
+		return
+	}
+	addrs := make([]string, 0, len(backends))
+	for addr := range backends {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	fmt.Println("backends:")
+	for _, addr := range addrs {
+		b := backends[addr]
+		fmt.Printf("  %-25s %8d requests %6d fails  avg %.2fms\n", addr, b.Requests, b.Fails, b.AvgMs)
+	}
+}
+
+func printProxySummary(p *stats.ProxyStats) {
+	if p == nil {
+		return
+	}
+	fmt.Printf("proxy: %d connects, %d failed, avg %.1fms, max %.1fms\n",
+		p.Connects, p.Failed, p.ConnectAvgMs, p.ConnectMaxMs)
+}
+
+func printH2Summary(h *stats.H2Stats) {
+	if h == nil {
+		return
+	}
+	fmt.Printf("http2: %d streams over %d connections (%.1f each, at most %d at once), %d reconnects, %d GOAWAY, %d RST_STREAM\n",
+		h.Streams, h.Connections, h.StreamsPerConn, h.PeakStreams, h.Reconnects, h.GoAways, h.Resets)
+}
+
+func printWSSummary(w *stats.WSStats) {
+	if w == nil {
+		return
+	}
+	fmt.Printf("websocket: %d connects, %d failed, avg %.1fms, max %.1fms, %d dropped, %d messages sent, %d received\n",
+		w.Connects, w.ConnectFails, w.ConnectAvgMs, w.ConnectMaxMs, w.Disconnects, w.Sent, w.Received)
+}
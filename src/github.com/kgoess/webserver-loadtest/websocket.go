@@ -0,0 +1,267 @@
+package main
+
+// --url ws://... or wss://..., for realtime services: each requester
+// holds a websocket open for as long as it's running, sending the
+// --ws-send messages in turn (or pings if there aren't any) at --ws-rate
+// a second. A round trip is the time from a message going out to the next
+// one coming back (the pong, for a ping), and counts as a request in the
+// stats, and no reply within --ws-wait is a fail. So is the server
+// dropping the connection, after which the requester reconnects after its
+// think time, and so is a message that can't go out within --ws-wait (or
+// wsclient's default, with --ws-wait 0) because the server's stopped
+// reading.
+//
+// The connections go through the same --resolve, --source-ip, --proxy and
+// TLS settings as the http requests. The --fault injection doesn't apply.
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	httpclient "github.com/kgoess/webserver-loadtest/httpclient"
+	stats "github.com/kgoess/webserver-loadtest/stats"
+	tmpl "github.com/kgoess/webserver-loadtest/tmpl"
+	workerpool "github.com/kgoess/webserver-loadtest/workerpool"
+	wsclient "github.com/kgoess/webserver-loadtest/wsclient"
+)
+
+// the --ws-send messages, with their placeholders parsed
+var wsSendSpecs stringList
+var wsMessages []*tmpl.Template
+
+// all the connections, for the stats
+var wsCounts = wsclient.NewCounts()
+
+func isWebSocketURL(u string) bool {
+	return strings.HasPrefix(u, "ws://") || strings.HasPrefix(u, "wss://")
+}
+
+func parseWSMessages(specs []string) error {
+	for _, spec := range specs {
+		t, err := templates.Parse(spec)
+		if err != nil {
+			return err
+		}
+		wsMessages = append(wsMessages, t)
+	}
+	return nil
+}
+
+// what came back from the server, or why it stopped
+type wsRead struct {
+	opcode int
+	data   []byte
+	at     time.Time
+	err    error
+}
+
+// one message waiting for its answer
+type wsPending struct {
+	sent time.Time
+	ping string // the payload, for matching up the pong
+}
+
+func runWebSocket(
+	ctx context.Context,
+	i int64,
+	infoMsgsCh chan<- ncursesMsg,
+	id int,
+	reqMadeOnSecCh chan<- interface{},
+	failsOnSecCh chan<- interface{},
+	durationCh chan<- interface{},
+	bytesPerSecCh chan<- interface{},
+) {
+	hitId := hitIds.ID(id, strconv.FormatInt(i, 10))
+	// the same env for the whole connection, so it's one --feed row
+	env := tmpl.NewEnv(id, map[string]string{
+		"worker":    strconv.Itoa(id),
+		"iteration": strconv.FormatInt(i, 10),
+	})
+	reqUrl := plainRequest.url.String()
+	fail := func(class string, text string) {
+		infoMsgsCh <- ncursesMsg{text, -1, MSG_TYPE_RESULT, hitId}
+		failsOnSecCh <- stats.Fail{Second: time.Now().Second(), Class: class}
+	}
+
+	thisUrl, err := plainRequest.url.Expand(env)
+	header := make(http.Header)
+	for _, h := range plainRequest.headers {
+		if err != nil {
+			break
+		}
+		var value string
+		if value, err = h.value.Expand(env); err == nil {
+			header.Set(h.name, value)
+		}
+	}
+	var req *http.Request
+	if err == nil {
+		req, err = http.NewRequest("GET", thisUrl, nil)
+	}
+	if err != nil {
+		ERROR.Println("can't fill in the websocket url: ", hitId, " ", err)
+		fail("script", "websocket "+reqUrl+": "+err.Error())
+		return
+	}
+	req.Header = header
+	hitIds.Tag(req, hitId)
+
+	// the connect time leaves out the --proxy, like the requests
+	dialCtx, timing := httpclient.WithTiming(ctx)
+	t0 := time.Now()
+	conn, resp, err := wsclient.Dial(dialCtx, req, httpClient.DialContext, httpClient.WrapTLS)
+	took := time.Since(t0) - timing.ProxyConnect()
+	if err != nil {
+		if ctx.Err() != nil {
+			INFO.Println("aborted websocket connect ", hitId)
+			return
+		}
+		wsCounts.ConnectFailed()
+		ERROR.Println("websocket connect failed: ", hitId, " ", err)
+		class := failureClass(nil, err)
+		if resp != nil && resp.StatusCode != http.StatusSwitchingProtocols {
+			class = failureClass(resp, nil)
+		}
+		fail(class, requestErrorText("websocket", reqUrl, err))
+		return
+	}
+	wsCounts.Connected(took)
+	TRACE.Println(id, "/", i, " websocket connected in ", took)
+	defer conn.Close()
+	if *wsWait > 0 {
+		conn.WriteTimeout = *wsWait
+	}
+
+	// the reader hands everything over, and stops when the connection
+	// does
+	done := make(chan struct{})
+	defer close(done)
+	readCh := make(chan wsRead)
+	go func() {
+		for {
+			op, data, err := conn.ReadMessage()
+			select {
+			case readCh <- wsRead{op, data, time.Now(), err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	var tick <-chan time.Time
+	if *wsRate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / *wsRate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	var timeouts <-chan time.Time
+	if *wsWait > 0 && tick != nil {
+		ticker := time.NewTicker(*wsWait / 4)
+		defer ticker.Stop()
+		timeouts = ticker.C
+	}
+
+	pending := list.New()
+	var seq int
+	for {
+		select {
+		case <-workerpool.Stopping(ctx):
+			wsCounts.Closed(false)
+			return
+		case <-ctx.Done():
+			wsCounts.Closed(false)
+			return
+
+		case <-tick:
+			if requesters.Paused() {
+				continue
+			}
+			seq++
+			var msg string
+			op := wsclient.PingMessage
+			if len(wsMessages) > 0 {
+				env.Vars["seq"] = strconv.Itoa(seq)
+				if msg, err = wsMessages[(seq-1)%len(wsMessages)].Expand(env); err != nil {
+					ERROR.Println("can't fill in the --ws-send: ", hitId, " ", err)
+					fail("script", "websocket "+reqUrl+": "+err.Error())
+					continue
+				}
+				op = wsclient.TextMessage
+			} else {
+				// just the seq, a ping only gets 125 bytes and the hit id
+				// can be longer than that with a long --run-id or --node
+				msg = strconv.Itoa(seq)
+			}
+			sent := time.Now()
+			if err := conn.WriteMessage(op, []byte(msg)); err != nil {
+				// a frame that's partly out leaves the connection no
+				// good, so it's a drop even if the reader hasn't noticed
+				if ctx.Err() != nil {
+					wsCounts.Closed(false)
+					return
+				}
+				wsCounts.Closed(true)
+				ERROR.Println("websocket write failed: ", hitId, " ", err)
+				fail(failureClass(nil, err), "websocket "+reqUrl+" dropped: "+err.Error())
+				return
+			}
+			if op != wsclient.PingMessage {
+				wsCounts.Sent()
+			}
+			if *wsWait > 0 {
+				p := &wsPending{sent: sent}
+				if op == wsclient.PingMessage {
+					p.ping = msg
+				}
+				pending.PushBack(p)
+			}
+
+		case now := <-timeouts:
+			for e := pending.Front(); e != nil && now.Sub(e.Value.(*wsPending).sent) > *wsWait; e = pending.Front() {
+				pending.Remove(e)
+				ERROR.Println("websocket reply timed out: ", hitId)
+				fail("timeout", "websocket "+reqUrl+": no reply in "+wsWait.String())
+			}
+
+		case r := <-readCh:
+			if r.err != nil {
+				if ctx.Err() != nil {
+					wsCounts.Closed(false)
+					return
+				}
+				wsCounts.Closed(true)
+				ERROR.Println("websocket dropped: ", hitId, " ", r.err)
+				fail("disconnect", "websocket "+reqUrl+" dropped: "+r.err.Error())
+				return
+			}
+			if r.opcode != wsclient.PongMessage {
+				wsCounts.Received()
+			}
+			// the reply's for the oldest message still waiting, or the
+			// ping with the same payload
+			var answered *list.Element
+			for e := pending.Front(); e != nil; e = e.Next() {
+				p := e.Value.(*wsPending)
+				if r.opcode == wsclient.PongMessage && p.ping == string(r.data) ||
+					r.opcode != wsclient.PongMessage && p.ping == "" {
+					answered = e
+					break
+				}
+			}
+			if answered == nil {
+				// a push from the server, or a reply we'd given up on
+				continue
+			}
+			pending.Remove(answered)
+			rtt := r.at.Sub(answered.Value.(*wsPending).sent)
+			reportResponse(reqMadeOnSecCh, durationCh, bytesPerSecCh, r.at.Second(), rtt, int64(len(r.data)))
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package webui
+
+// The whole dashboard is this one page, so it gets compiled right into
+// the binary--no static files to lose track of when you scp it to a
+// load box.
+const page = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>webserver-loadtest</title>
+<style>
+  body { background: #000; color: #ddd; font-family: monospace; margin: 1em; }
+  .stats { display: flex; gap: 2em; margin-bottom: 1em; }
+  .stat span { display: block; color: #888; font-size: 80%; }
+  .stat b { font-size: 150%; }
+  canvas { border: 1px solid #555; display: block; margin-bottom: 0.5em; }
+  #status { color: #888; }
+</style>
+</head>
+<body>
+<div class="stats">
+  <div class="stat"><span>thrds</span><b id="workers">0</b></div>
+  <div class="stat"><span>duration ms (avg last 5)</span><b id="latency">0</b></div>
+  <div class="stat"><span>req/s 1/5/60</span><b id="reqsec">0/0/0</b></div>
+  <div class="stat"><span>bytes/s</span><b id="bytes">0</b></div>
+  <div class="stat"><span>max</span><b id="max">0</b></div>
+  <div class="stat"><span>scale</span><b id="scale">1</b></div>
+</div>
+<div>req/s (fails in red)</div>
+<canvas id="bars" width="1200" height="300"></canvas>
+<div>duration ms</div>
+<canvas id="latencyChart" width="1200" height="150"></canvas>
+<div>
+  history:
+  <select id="window">
+    <option value="60">1 min</option>
+    <option value="300" selected>5 min</option>
+    <option value="900">15 min</option>
+    <option value="3600">1 hour</option>
+  </select>
+  <span id="status">connecting...</span>
+</div>
+<script>
+var samples = [];
+var maxSamples = 3600;
+
+function $(id) { return document.getElementById(id); }
+
+function windowSecs() { return parseInt($("window").value, 10); }
+
+function visible() {
+  return samples.slice(Math.max(0, samples.length - windowSecs()));
+}
+
+function drawBars() {
+  var c = $("bars"), ctx = c.getContext("2d");
+  var data = visible(), n = windowSecs();
+  ctx.clearRect(0, 0, c.width, c.height);
+  var max = 1;
+  data.forEach(function (s) { if (s.reqSec + s.fails > max) max = s.reqSec + s.fails; });
+  var w = c.width / n;
+  var x0 = c.width - data.length * w;
+  data.forEach(function (s, i) {
+    var x = x0 + i * w;
+    var okH = s.reqSec / max * c.height;
+    var failH = s.fails / max * c.height;
+    // always show a marker if there were *any* fails, same as the curses display
+    if (s.fails > 0 && failH < 2) failH = 2;
+    ctx.fillStyle = (i >= data.length - 2) ? "#0c0" : "#ccc";
+    ctx.fillRect(x, c.height - okH, Math.max(w - 1, 1), okH);
+    ctx.fillStyle = "#e00";
+    ctx.fillRect(x, c.height - failH, Math.max(w - 1, 1), failH);
+  });
+  ctx.fillStyle = "#888";
+  ctx.fillText(max, 2, 10);
+}
+
+function drawLatency() {
+  var c = $("latencyChart"), ctx = c.getContext("2d");
+  var data = visible(), n = windowSecs();
+  ctx.clearRect(0, 0, c.width, c.height);
+  var max = 1;
+  data.forEach(function (s) { if (s.latencyMs > max) max = s.latencyMs; });
+  var w = c.width / n;
+  var x0 = c.width - data.length * w;
+  ctx.strokeStyle = "#fc0";
+  ctx.beginPath();
+  data.forEach(function (s, i) {
+    var x = x0 + i * w + w / 2;
+    var y = c.height - s.latencyMs / max * (c.height - 2);
+    if (i === 0) ctx.moveTo(x, y); else ctx.lineTo(x, y);
+  });
+  ctx.stroke();
+  ctx.fillStyle = "#888";
+  ctx.fillText(max.toFixed(1), 2, 10);
+}
+
+function showLatest(s) {
+  $("workers").textContent = s.workers;
+  $("latency").textContent = s.latencyMs.toFixed(2);
+  $("reqsec").textContent = s.reqSec + "/" + s.reqSecAvg5 + "/" + s.reqSecAvg60;
+  $("bytes").textContent = s.bytesPerSec.toFixed(2);
+  $("max").textContent = s.max;
+  $("scale").textContent = s.scale;
+}
+
+function redraw() {
+  if (samples.length > 0) showLatest(samples[samples.length - 1]);
+  drawBars();
+  drawLatency();
+}
+
+function add(s) {
+  samples.push(s);
+  if (samples.length > maxSamples) samples.shift();
+  redraw();
+}
+
+$("window").onchange = redraw;
+
+fetch("history").then(function (r) { return r.json(); }).then(function (h) {
+  samples = h || [];
+  redraw();
+  var es = new EventSource("events");
+  es.onopen = function () { $("status").textContent = "live"; };
+  es.onerror = function () { $("status").textContent = "disconnected, retrying..."; };
+  es.onmessage = function (e) { add(JSON.parse(e.data)); };
+});
+</script>
+</body>
+</html>
+`
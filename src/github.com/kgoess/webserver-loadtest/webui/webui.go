@@ -0,0 +1,164 @@
+package webui
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	stats "github.com/kgoess/webserver-loadtest/stats"
+)
+
+// debugging kludge--is this really the way to share global loggers?
+var (
+	INFO *log.Logger
+)
+
+// One of these gets published every second, it's the same stuff that
+// drawDisplay shows in the ncurses windows
+type Sample struct {
+	Time        int64   `json:"t"` // unix seconds
+	Workers     int     `json:"workers"`
+	ReqSec      int64   `json:"reqSec"` // requests made in the last complete second
+	ReqSecAvg5  int64   `json:"reqSecAvg5"`
+	ReqSecAvg60 int64   `json:"reqSecAvg60"`
+	Fails       int64   `json:"fails"`     // fails in the last complete second
+	LatencyMs   float64 `json:"latencyMs"` // moving average
+	BytesPerSec float64 `json:"bytesPerSec"`
+	Max         int64   `json:"max"`
+	Scale       int64   `json:"scale"`
+}
+
+type Dashboard struct {
+	mu          sync.Mutex
+	history     []Sample
+	historyLen  int
+	subscribers map[chan []byte]bool
+}
+
+// historyLen is how many seconds of samples we hang onto for clients
+// that connect in the middle of a run
+func MakeNew(historyLen int, infoLog *log.Logger) *Dashboard {
+	d := new(Dashboard)
+	d.historyLen = historyLen
+	d.history = make([]Sample, 0, historyLen)
+	d.subscribers = make(map[chan []byte]bool)
+	INFO = infoLog
+	return d
+}
+
+// Report makes the Dashboard a stats.Reporter
+func (d *Dashboard) Report(s stats.Snapshot) {
+	d.Publish(Sample{
+		Time:        s.Time.Unix(),
+		Workers:     s.Workers,
+		ReqSec:      s.ReqSec,
+		ReqSecAvg5:  s.ReqSecAvg5,
+		ReqSecAvg60: s.ReqSecAvg60,
+		Fails:       s.Fails,
+		LatencyMs:   s.LatencyMs,
+		BytesPerSec: s.BytesPerSec,
+		Max:         s.Max,
+		Scale:       stats.Scale(s.Max, stats.DefaultBarRows),
+	})
+}
+
+// Publish is called from the main loop, so it must never block on a slow
+// browser--if a subscriber's buffer is full it just misses that second.
+func (d *Dashboard) Publish(s Sample) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		INFO.Printf("couldn't marshal dashboard sample: %v", err)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.history) >= d.historyLen {
+		d.history = append(d.history[:0], d.history[1:]...)
+	}
+	d.history = append(d.history, s)
+
+	for ch := range d.subscribers {
+		select {
+		case ch <- data:
+		default:
+		}
+	}
+}
+
+func (d *Dashboard) History() []Sample {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	h := make([]Sample, len(d.history))
+	copy(h, d.history)
+	return h
+}
+
+func (d *Dashboard) subscribe() chan []byte {
+	ch := make(chan []byte, 16)
+	d.mu.Lock()
+	d.subscribers[ch] = true
+	d.mu.Unlock()
+	return ch
+}
+
+func (d *Dashboard) unsubscribe(ch chan []byte) {
+	d.mu.Lock()
+	delete(d.subscribers, ch)
+	d.mu.Unlock()
+}
+
+// Register hooks the dashboard pages into the mux, it takes over "/" so
+// anything else sharing the port needs a more specific pattern.
+func (d *Dashboard) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/", d.servePage)
+	mux.HandleFunc("/history", d.serveHistory)
+	mux.HandleFunc("/events", d.serveEvents)
+}
+
+func (d *Dashboard) servePage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, page)
+}
+
+func (d *Dashboard) serveHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(d.History()); err != nil {
+		INFO.Printf("writing dashboard history failed: %v", err)
+	}
+}
+
+// Server-Sent Events, one "data:" line per second
+func (d *Dashboard) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher.Flush()
+
+	ch := d.subscribe()
+	defer d.unsubscribe(ch)
+
+	for {
+		select {
+		case data := <-ch:
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
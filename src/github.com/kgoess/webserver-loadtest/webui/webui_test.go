@@ -0,0 +1,137 @@
+package webui
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	stats "github.com/kgoess/webserver-loadtest/stats"
+)
+
+func makeTestServer(historyLen int) (*Dashboard, *httptest.Server) {
+	d := MakeNew(historyLen, log.New(ioutil.Discard, "", 0))
+	mux := http.NewServeMux()
+	d.Register(mux)
+	return d, httptest.NewServer(mux)
+}
+
+func TestDashboardHistory(t *testing.T) {
+	d, ts := makeTestServer(3)
+	defer ts.Close()
+
+	for i := int64(1); i <= 5; i++ {
+		d.Publish(Sample{Time: i, ReqSec: i * 10})
+	}
+
+	resp, err := http.Get(ts.URL + "/history")
+	if err != nil {
+		t.Fatalf("get /history failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var history []Sample
+	if err := json.NewDecoder(resp.Body).Decode(&history); err != nil {
+		t.Fatalf("decoding /history failed: %v", err)
+	}
+	// we only keep the last 3
+	if len(history) != 3 {
+		t.Fatalf("history length s/b 3, got %d", len(history))
+	}
+	if history[0].Time != 3 || history[2].ReqSec != 50 {
+		t.Errorf("history s/b seconds 3..5, got %v", history)
+	}
+}
+
+func TestDashboardReport(t *testing.T) {
+	d, ts := makeTestServer(10)
+	defer ts.Close()
+
+	d.Report(stats.Snapshot{Time: time.Unix(1414000000, 0), Workers: 3, Max: 100, LatencyMs: 1.5})
+	h := d.History()
+	if len(h) != 1 {
+		t.Fatalf("history s/b 1 long, got %d", len(h))
+	}
+	if h[0].Time != 1414000000 || h[0].Workers != 3 || h[0].LatencyMs != 1.5 {
+		t.Errorf("sample doesn't match the snapshot: %+v", h[0])
+	}
+	// 100 over 25 rows
+	if h[0].Scale != 5 {
+		t.Errorf("scale s/b 5, got %d", h[0].Scale)
+	}
+}
+
+func TestDashboardEvents(t *testing.T) {
+	d, ts := makeTestServer(10)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/events")
+	if err != nil {
+		t.Fatalf("get /events failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("content-type s/b text/event-stream, got %s", ct)
+	}
+
+	// the subscription happens in the handler, so keep publishing until
+	// the stream sees something
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				d.Publish(Sample{Time: 42, Workers: 7, Fails: 2})
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}()
+
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading event stream failed: %v", err)
+	}
+	if !strings.HasPrefix(line, "data: ") {
+		t.Fatalf("expected a data: line, got %q", line)
+	}
+	var s Sample
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &s); err != nil {
+		t.Fatalf("unmarshalling event failed: %v", err)
+	}
+	if s.Workers != 7 || s.Fails != 2 {
+		t.Errorf("got wrong sample back: %+v", s)
+	}
+}
+
+func TestDashboardPage(t *testing.T) {
+	_, ts := makeTestServer(10)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatalf("get / failed: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "EventSource") {
+		t.Errorf("page doesn't look like the dashboard")
+	}
+
+	resp, err = http.Get(ts.URL + "/nosuchthing")
+	if err != nil {
+		t.Fatalf("get /nosuchthing failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Errorf("unknown path s/b 404, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,237 @@
+package workerpool
+
+// The requester goroutines. Each worker calls the WorkFunc over and over
+// until it's told to stop; Remove lets the current request finish, Drain
+// waits for everything in flight and then cancels the contexts of
+// whatever's still going. IDs are never reused, so a hitid in the server
+// logs always means the same worker.
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// debugging kludge--is this really the way to share global loggers?
+var (
+	INFO *log.Logger
+)
+
+// one request, i counts up from 1 for each worker. ctx gets cancelled if
+// we're giving up on the request.
+type WorkFunc func(ctx context.Context, id int, i int64)
+
+type stopKey struct{}
+
+// Stopping is for work that goes on until it's told otherwise, like a
+// websocket: it's closed when the worker's been asked to stop after the
+// current request, before the ctx gets cancelled. nil (which never
+// closes) if the ctx isn't from a worker.
+func Stopping(ctx context.Context) <-chan struct{} {
+	stop, _ := ctx.Value(stopKey{}).(chan struct{})
+	return stop
+}
+
+type worker struct {
+	id     int
+	stop   chan struct{}      // closed to stop after the current request
+	cancel context.CancelFunc // aborts the current request
+}
+
+type Pool struct {
+	work WorkFunc
+	// how long each worker waits after a request before the next one,
+	// nil for no wait. It's not in flight then, and a stop doesn't wait
+	// for it. Set it before the first Add.
+	Think func() time.Duration
+	// called with the worker's id once it's stopped for good, nil for
+	// nothing to do. Set it before the first Add too.
+	Done func(id int)
+
+	mu       sync.Mutex
+	workers  []*worker       // oldest first, Remove takes the newest
+	running  map[int]*worker // including Removed ones finishing a request
+	nextID   int
+	resumed  chan struct{} // non-nil while paused, closed by Resume
+	draining bool          // no more Adds once we're shutting down
+	inFlight int64         // atomic
+	wg       sync.WaitGroup
+}
+
+func MakeNew(work WorkFunc, infoLog *log.Logger) *Pool {
+	INFO = infoLog
+	return &Pool{work: work, running: make(map[int]*worker)}
+}
+
+// starts one more worker, ok is false if we're draining
+func (p *Pool) Add() (id int, ok bool) {
+	p.mu.Lock()
+	if p.draining {
+		p.mu.Unlock()
+		return 0, false
+	}
+	stop := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), stopKey{}, stop))
+	w := &worker{id: p.nextID, stop: stop, cancel: cancel}
+	p.nextID++
+	p.workers = append(p.workers, w)
+	p.running[w.id] = w
+	// under the lock, so a Drain can't get to its Wait before this worker's
+	// counted
+	p.wg.Add(1)
+	p.mu.Unlock()
+
+	go p.run(ctx, w)
+	return w.id, true
+}
+
+// stops the newest worker once its current request is done, ok is false
+// if there weren't any
+func (p *Pool) Remove() (id int, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.workers) == 0 {
+		return 0, false
+	}
+	w := p.workers[len(p.workers)-1]
+	p.workers = p.workers[:len(p.workers)-1]
+	close(w.stop)
+	return w.id, true
+}
+
+func (p *Pool) run(ctx context.Context, w *worker) {
+	defer p.wg.Done()
+	defer func() {
+		w.cancel()
+		p.mu.Lock()
+		delete(p.running, w.id)
+		p.mu.Unlock()
+		if p.Done != nil {
+			p.Done(w.id)
+		}
+	}()
+	var i int64
+	for {
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+
+		p.mu.Lock()
+		resumed := p.resumed
+		p.mu.Unlock()
+		if resumed != nil {
+			select {
+			case <-resumed:
+			case <-w.stop:
+				return
+			}
+		}
+
+		i++
+		atomic.AddInt64(&p.inFlight, 1)
+		p.work(ctx, w.id, i)
+		atomic.AddInt64(&p.inFlight, -1)
+		p.think(ctx, w)
+	}
+}
+
+func (p *Pool) think(ctx context.Context, w *worker) {
+	if p.Think == nil {
+		return
+	}
+	d := p.Think()
+	if d <= 0 {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-w.stop:
+	case <-ctx.Done():
+	}
+}
+
+// the workers finish what they're doing and then wait for Resume
+func (p *Pool) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resumed == nil {
+		p.resumed = make(chan struct{})
+	}
+}
+
+func (p *Pool) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resumed != nil {
+		close(p.resumed)
+		p.resumed = nil
+	}
+}
+
+func (p *Pool) Paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.resumed != nil
+}
+
+// how many workers are running, not counting ones that have been told to
+// stop but are finishing a request
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.workers)
+}
+
+// how many requests are going right now
+func (p *Pool) InFlight() int64 {
+	return atomic.LoadInt64(&p.inFlight)
+}
+
+// Drain stops all the workers, waits up to timeout for the requests in
+// flight to finish, and then aborts the rest. Returns false if it had to
+// abort anything. Whoever's reading the WorkFunc's output has to keep
+// reading until this returns.
+func (p *Pool) Drain(timeout time.Duration) bool {
+	p.mu.Lock()
+	p.draining = true
+	for _, w := range p.workers {
+		close(w.stop)
+	}
+	p.workers = nil
+	p.mu.Unlock()
+	// paused workers are waiting on stop too, but don't leave them
+	// wondering
+	p.Resume()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+	}
+
+	INFO.Printf("aborting %d requests still in flight after %v", p.InFlight(), timeout)
+	p.mu.Lock()
+	for _, w := range p.running {
+		w.cancel()
+	}
+	p.mu.Unlock()
+	// give everybody a moment to notice
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		INFO.Println("some workers still didn't stop")
+	}
+	return false
+}
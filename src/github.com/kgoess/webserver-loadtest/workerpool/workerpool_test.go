@@ -0,0 +1,202 @@
+package workerpool
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var discard = log.New(ioutil.Discard, "", 0)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestUniqueIDs(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[int]bool)
+	p := MakeNew(func(ctx context.Context, id int, i int64) {
+		mu.Lock()
+		seen[id] = true
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+	}, discard)
+
+	a, _ := p.Add()
+	b, _ := p.Add()
+	if id, ok := p.Remove(); !ok || id != b {
+		t.Errorf("Remove s/b the newest, %d, got %d", b, id)
+	}
+	c, _ := p.Add()
+	if a == b || b == c || a == c {
+		t.Errorf("ids s/b unique, got %d %d %d", a, b, c)
+	}
+	if p.Len() != 2 {
+		t.Errorf("Len s/b 2, got %d", p.Len())
+	}
+	waitFor(t, "worker c to run", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return seen[c]
+	})
+	p.Drain(time.Second)
+	if _, ok := p.Remove(); ok {
+		t.Errorf("Remove after Drain s/b nothing left")
+	}
+	if _, ok := p.Add(); ok {
+		t.Errorf("Add after Drain s/b refused")
+	}
+}
+
+func TestInFlightAndDrain(t *testing.T) {
+	release := make(chan struct{})
+	p := MakeNew(func(ctx context.Context, id int, i int64) {
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+	}, discard)
+
+	for i := 0; i < 3; i++ {
+		p.Add()
+	}
+	waitFor(t, "3 in flight", func() bool { return p.InFlight() == 3 })
+
+	// they finish on their own inside the timeout
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+	}()
+	if !p.Drain(time.Second) {
+		t.Errorf("Drain s/b clean when the requests finish in time")
+	}
+	if p.InFlight() != 0 {
+		t.Errorf("in flight s/b 0 after Drain, got %d", p.InFlight())
+	}
+}
+
+func TestDrainWhileThinking(t *testing.T) {
+	var done int64
+	p := MakeNew(func(ctx context.Context, id int, i int64) {
+		atomic.AddInt64(&done, 1)
+	}, discard)
+	p.Think = func() time.Duration { return time.Minute }
+	var finished int64
+	p.Done = func(id int) { atomic.AddInt64(&finished, 1) }
+
+	for i := 0; i < 3; i++ {
+		p.Add()
+	}
+	waitFor(t, "3 requests done", func() bool { return atomic.LoadInt64(&done) == 3 })
+	if p.InFlight() != 0 {
+		t.Errorf("thinking isn't in flight, got %d", p.InFlight())
+	}
+	start := time.Now()
+	if !p.Drain(time.Second) {
+		t.Errorf("Drain s/b clean, nothing was in flight")
+	}
+	if took := time.Since(start); took > 500*time.Millisecond {
+		t.Errorf("Drain shouldn't wait out the think time, took %v", took)
+	}
+	if n := atomic.LoadInt64(&done); n != 3 {
+		t.Errorf("no more requests s/b started after the stop, got %d", n)
+	}
+	if n := atomic.LoadInt64(&finished); n != 3 {
+		t.Errorf("Done s/b called for all 3 workers, got %d", n)
+	}
+}
+
+func TestDrainAborts(t *testing.T) {
+	aborted := make(chan int, 10)
+	p := MakeNew(func(ctx context.Context, id int, i int64) {
+		<-ctx.Done()
+		aborted <- id
+	}, discard)
+
+	p.Add()
+	p.Add()
+	waitFor(t, "2 in flight", func() bool { return p.InFlight() == 2 })
+	// a Removed worker stuck in a request gets aborted too
+	p.Remove()
+
+	if p.Drain(10 * time.Millisecond) {
+		t.Errorf("Drain s/b reporting it had to abort")
+	}
+	if len(aborted) != 2 {
+		t.Errorf("both requests s/b aborted, got %d", len(aborted))
+	}
+}
+
+func TestPause(t *testing.T) {
+	var mu sync.Mutex
+	count := 0
+	p := MakeNew(func(ctx context.Context, id int, i int64) {
+		mu.Lock()
+		count++
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+	}, discard)
+	get := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return count
+	}
+
+	p.Add()
+	waitFor(t, "some requests", func() bool { return get() > 0 })
+	p.Pause()
+	if !p.Paused() {
+		t.Errorf("Paused s/b true")
+	}
+	waitFor(t, "the current request to finish", func() bool { return p.InFlight() == 0 })
+	before := get()
+	time.Sleep(20 * time.Millisecond)
+	if after := get(); after != before {
+		t.Errorf("no requests s/b made while paused, went from %d to %d", before, after)
+	}
+	p.Resume()
+	waitFor(t, "requests after resuming", func() bool { return get() > before })
+
+	// paused workers still drain
+	p.Pause()
+	if !p.Drain(time.Second) {
+		t.Errorf("Drain of paused workers s/b clean")
+	}
+}
+
+func TestStopping(t *testing.T) {
+	if Stopping(context.Background()) != nil {
+		t.Errorf("Stopping s/b nil for a ctx that's not from a worker")
+	}
+	// work that only ends when it's told to
+	var calls int64
+	p := MakeNew(func(ctx context.Context, id int, i int64) {
+		atomic.AddInt64(&calls, 1)
+		select {
+		case <-Stopping(ctx):
+		case <-ctx.Done():
+			t.Errorf("ctx s/b not cancelled before Stopping")
+		}
+	}, discard)
+	p.Add()
+	p.Add()
+	waitFor(t, "both to start", func() bool { return p.InFlight() == 2 })
+	p.Remove()
+	waitFor(t, "the removed one to finish", func() bool { return p.InFlight() == 1 })
+	if !p.Drain(time.Second) {
+		t.Errorf("Drain s/b clean when the work watches Stopping")
+	}
+	if n := atomic.LoadInt64(&calls); n != 2 {
+		t.Errorf("s/b one call each, got %d", n)
+	}
+}
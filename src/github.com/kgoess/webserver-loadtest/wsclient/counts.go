@@ -0,0 +1,88 @@
+package wsclient
+
+import (
+	"sync"
+	"time"
+
+	stats "github.com/kgoess/webserver-loadtest/stats"
+)
+
+// Counts keeps track of all the connections, for the stats
+type Counts struct {
+	mu           sync.Mutex
+	open         int64
+	connects     int64
+	connectFails int64
+	disconnects  int64
+	totalTime    time.Duration
+	maxTime      time.Duration
+	sent         int64
+	received     int64
+}
+
+func NewCounts() *Counts {
+	return &Counts{}
+}
+
+// Connected is a handshake that worked, and how long the connecting took
+func (c *Counts) Connected(took time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.open++
+	c.connects++
+	c.totalTime += took
+	if took > c.maxTime {
+		c.maxTime = took
+	}
+}
+
+func (c *Counts) ConnectFailed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connectFails++
+}
+
+// Closed is a connection going away, dropped is if that wasn't us
+// deciding to close it
+func (c *Counts) Closed(dropped bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.open--
+	if dropped {
+		c.disconnects++
+	}
+}
+
+func (c *Counts) Sent() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent++
+}
+
+func (c *Counts) Received() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.received++
+}
+
+// Stats is everything so far, nil if nothing's tried to connect
+func (c *Counts) Stats() *stats.WSStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.connects == 0 && c.connectFails == 0 {
+		return nil
+	}
+	s := &stats.WSStats{
+		Open:         c.open,
+		Connects:     c.connects,
+		ConnectFails: c.connectFails,
+		Disconnects:  c.disconnects,
+		ConnectMaxMs: float64(c.maxTime) / float64(time.Millisecond),
+		Sent:         c.sent,
+		Received:     c.received,
+	}
+	if c.connects > 0 {
+		s.ConnectAvgMs = float64(c.totalTime) / float64(c.connects) / float64(time.Millisecond)
+	}
+	return s
+}
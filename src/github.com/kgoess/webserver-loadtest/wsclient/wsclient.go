@@ -0,0 +1,399 @@
+package wsclient
+
+// Just enough of a WebSocket client (RFC 6455) to load test with: the
+// handshake, masked frames going out, fragmented messages coming in put
+// back together, pings answered, and the close handshake. No extensions
+// (so no compression) and no subprotocols unless you ask for one in the
+// headers yourself.
+//
+// How it connects is up to the caller, so the same --resolve, --proxy,
+// --source-ip and TLS settings can apply as for the http requests.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	continuation  = 0
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+)
+
+// the most we'll put together for one message, so a misbehaving server
+// can't eat all our memory
+const MaxMessageSize = 16 << 20
+
+const handshakeTimeout = 30 * time.Second
+
+// how long a frame gets to go out, unless the Conn says otherwise
+const DefaultWriteTimeout = 10 * time.Second
+
+// what the server's Sec-WebSocket-Accept is made from
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// CloseError is the server closing the connection, with its status code
+// and reason, 1005 if it didn't give one
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("closed by the server: %d", e.Code)
+	}
+	return fmt.Sprintf("closed by the server: %d %s", e.Code, e.Reason)
+}
+
+type Conn struct {
+	// each frame has this long to go out, or it's an error and the
+	// connection's no good any more. Set it before anything's reading or
+	// writing.
+	WriteTimeout time.Duration
+
+	conn net.Conn
+	br   *bufio.Reader
+
+	writeMu sync.Mutex
+	closed  bool // we've sent our close frame, under writeMu
+
+	done      chan struct{} // closed by Close, for the ctx watcher
+	closeOnce sync.Once
+}
+
+type DialFunc func(ctx context.Context, network string, addr string) (net.Conn, error)
+type TLSFunc func(conn net.Conn, host string) (net.Conn, error)
+
+// Dial does the handshake for req, a GET for a ws:// or wss:// url with
+// any extra headers. dial and wrapTLS are how to connect, nil for the
+// plain defaults. The response is for the status when the server turns
+// us down. ctx is for the life of the connection, when it's done the
+// connection gets closed, which gets anything reading or writing out.
+func Dial(ctx context.Context, req *http.Request, dial DialFunc, wrapTLS TLSFunc) (*Conn, *http.Response, error) {
+	u := req.URL
+	var secure bool
+	switch u.Scheme {
+	case "ws":
+	case "wss":
+		secure = true
+	default:
+		return nil, nil, fmt.Errorf("websocket url s/b ws:// or wss://, got %s", u)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		if secure {
+			addr = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			addr = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: handshakeTimeout}).DialContext
+	}
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the handshake has to happen in time, or when the ctx says to give up
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	stop := make(chan struct{})
+	defer close(stop)
+	raw := conn
+	go func() {
+		select {
+		case <-ctx.Done():
+			raw.Close()
+		case <-stop:
+		}
+	}()
+
+	if secure {
+		if wrapTLS == nil {
+			wrapTLS = defaultTLS
+		}
+		tlsConn, err := wrapTLS(conn, u.Hostname())
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		conn = tlsConn
+	}
+
+	c, resp, err := handshake(conn, req)
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, resp, err
+	}
+	conn.SetDeadline(time.Time{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.conn.Close()
+		case <-c.done:
+		}
+	}()
+	return c, resp, nil
+}
+
+func handshake(conn net.Conn, req *http.Request) (*Conn, *http.Response, error) {
+	var nonce [16]byte
+	rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	// req.Write would want an http:// url
+	u := req.URL
+	path := u.RequestURI()
+	host := req.Host
+	if host == "" {
+		host = u.Host
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "GET %s HTTP/1.1\r\nHost: %s\r\n", path, host)
+	b.WriteString("Upgrade: websocket\r\nConnection: Upgrade\r\n")
+	fmt.Fprintf(&b, "Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n", key)
+	for name, values := range req.Header {
+		for _, v := range values {
+			fmt.Fprintf(&b, "%s: %s\r\n", name, v)
+		}
+	}
+	b.WriteString("\r\n")
+	if _, err := conn.Write(b.Bytes()); err != nil {
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: "GET", URL: u})
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		resp.Body.Close()
+		return nil, resp, fmt.Errorf("handshake: %s", resp.Status)
+	}
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") {
+		return nil, resp, fmt.Errorf("handshake: the server didn't upgrade to websocket")
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, resp, fmt.Errorf("handshake: wrong Sec-WebSocket-Accept")
+	}
+	return &Conn{WriteTimeout: DefaultWriteTimeout, conn: conn, br: br, done: make(chan struct{})}, resp, nil
+}
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	io.WriteString(h, key+acceptGUID)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func defaultTLS(conn net.Conn, host string) (net.Conn, error) {
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: host})
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// WriteMessage sends one frame, which is all of the message
+func (c *Conn) WriteMessage(opcode int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return errors.New("websocket already closed")
+	}
+	return c.writeFrame(opcode, data, c.WriteTimeout)
+}
+
+// under writeMu
+func (c *Conn) writeFrame(opcode int, data []byte, timeout time.Duration) error {
+	if opcode >= CloseMessage && len(data) > 125 {
+		// RFC 6455 5.5, the server would hang up on us
+		return fmt.Errorf("control frame over 125 bytes (%d)", len(data))
+	}
+	header := make([]byte, 2, 14)
+	header[0] = 0x80 | byte(opcode) // FIN
+	n := len(data)
+	switch {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = header[:4]
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header[1] = 127
+		header = header[:10]
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+	// everything from a client is masked
+	header[1] |= 0x80
+	var mask [4]byte
+	rand.Read(mask[:])
+	header = append(header, mask[:]...)
+
+	frame := make([]byte, len(header)+n)
+	copy(frame, header)
+	for i, b := range data {
+		frame[len(header)+i] = b ^ mask[i%4]
+	}
+	if timeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// ReadMessage returns the next text, binary or pong message. Pings get
+// answered on the way. When the server closes the connection it's a
+// *CloseError.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	var opcode int
+	var message []byte
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case PingMessage:
+			c.writeMu.Lock()
+			if !c.closed {
+				err = c.writeFrame(PongMessage, payload, c.WriteTimeout)
+			}
+			c.writeMu.Unlock()
+			if err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			return PongMessage, payload, nil
+		case CloseMessage:
+			closeErr := &CloseError{Code: 1005}
+			if len(payload) >= 2 {
+				closeErr.Code = int(binary.BigEndian.Uint16(payload))
+				closeErr.Reason = string(payload[2:])
+			}
+			// say goodbye back with the same code, if we haven't already
+			echo := payload
+			if len(echo) > 2 {
+				echo = echo[:2]
+			}
+			c.writeMu.Lock()
+			if !c.closed {
+				c.closed = true
+				c.writeFrame(CloseMessage, echo, time.Second)
+			}
+			c.writeMu.Unlock()
+			c.hangUp()
+			return 0, nil, closeErr
+		case TextMessage, BinaryMessage:
+			if opcode != 0 {
+				return 0, nil, errors.New("new message in the middle of a fragmented one")
+			}
+			opcode = op
+		case continuation:
+			if opcode == 0 {
+				return 0, nil, errors.New("continuation frame without a message")
+			}
+		default:
+			return 0, nil, fmt.Errorf("unknown opcode %d", op)
+		}
+		if len(message)+len(payload) > MaxMessageSize {
+			return 0, nil, fmt.Errorf("message over %d bytes", MaxMessageSize)
+		}
+		message = append(message, payload...)
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+func (c *Conn) readFrame() (fin bool, opcode int, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.br, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	opcode = int(head[0] & 0x0f)
+	masked := head[1]&0x80 != 0
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if opcode >= CloseMessage && (n > 125 || !fin) {
+		err = errors.New("bad control frame")
+		return
+	}
+	if n > MaxMessageSize {
+		err = fmt.Errorf("frame over %d bytes", MaxMessageSize)
+		return
+	}
+	// servers aren't supposed to mask, but it's easy enough to undo
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// Close says goodbye (1000, normal closure) and hangs up without waiting
+// for the server to say it back
+func (c *Conn) Close() error {
+	// a write that's stuck because the server isn't reading gives up
+	// now instead of holding on to writeMu for its whole WriteTimeout
+	c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	c.writeMu.Lock()
+	if !c.closed {
+		c.closed = true
+		c.writeFrame(CloseMessage, []byte{0x03, 0xe8}, time.Second)
+	}
+	c.writeMu.Unlock()
+	return c.hangUp()
+}
+
+func (c *Conn) hangUp() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return c.conn.Close()
+}
@@ -0,0 +1,256 @@
+package wsclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the server end of a connection, which reads with the client's code
+// (it unmasks) and writes unmasked frames
+type serverConn struct {
+	*Conn
+}
+
+func (s serverConn) send(fin bool, opcode int, data []byte) {
+	head := []byte{byte(opcode), 0}
+	if fin {
+		head[0] |= 0x80
+	}
+	switch n := len(data); {
+	case n < 126:
+		head[1] = byte(n)
+	case n <= 0xffff:
+		head[1] = 126
+		head = append(head, 0, 0)
+		binary.BigEndian.PutUint16(head[2:], uint16(n))
+	default:
+		head[1] = 127
+		head = append(head, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(head[2:], uint64(n))
+	}
+	s.conn.Write(append(head, data...))
+}
+
+// an echo server, with a few extra tricks depending on the message
+func echoServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") == "wrong" {
+			http.Error(w, "go away", http.StatusForbidden)
+			return
+		}
+		accept := acceptKey(r.Header.Get("Sec-WebSocket-Key"))
+		if r.URL.Query().Get("badkey") != "" {
+			accept = "nope"
+		}
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		defer conn.Close()
+		conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " + accept + "\r\n\r\n"))
+		s := serverConn{&Conn{conn: conn, br: bufio.NewReader(conn)}}
+		for {
+			fin, op, payload, err := s.readFrame()
+			if err != nil {
+				return
+			}
+			if !fin {
+				t.Errorf("the client s/b sending whole messages")
+			}
+			switch {
+			case op == PingMessage:
+				s.send(true, PongMessage, payload)
+			case op == CloseMessage:
+				s.send(true, CloseMessage, payload)
+				return
+			case string(payload) == "fragments":
+				s.send(false, TextMessage, []byte("frag"))
+				s.send(true, PingMessage, []byte("in between"))
+				s.send(false, continuation, []byte("men"))
+				s.send(true, continuation, []byte("ts"))
+			case string(payload) == "bye":
+				s.send(true, CloseMessage, []byte{0x03, 0xf0, 'b', 'u', 's', 'y'})
+			default:
+				s.send(true, op, payload)
+			}
+		}
+	}))
+}
+
+func dial(t *testing.T, srv *httptest.Server, path string, header http.Header) (*Conn, *http.Response, error) {
+	req, _ := http.NewRequest("GET", "ws"+strings.TrimPrefix(srv.URL, "http")+path, nil)
+	if header != nil {
+		req.Header = header
+	}
+	return Dial(context.Background(), req, nil, nil)
+}
+
+func TestEcho(t *testing.T) {
+	srv := echoServer(t)
+	defer srv.Close()
+	c, _, err := dial(t, srv, "/", nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer c.Close()
+
+	for _, msg := range [][]byte{
+		[]byte("hello"),
+		bytes.Repeat([]byte("m"), 300),   // 16 bit length
+		bytes.Repeat([]byte("l"), 70000), // 64 bit length
+	} {
+		if err := c.WriteMessage(BinaryMessage, msg); err != nil {
+			t.Fatalf("WriteMessage failed: %v", err)
+		}
+		op, got, err := c.ReadMessage()
+		if err != nil || op != BinaryMessage || !bytes.Equal(got, msg) {
+			t.Errorf("s/b the %d bytes echoed, got %d %d bytes %v", len(msg), op, len(got), err)
+		}
+	}
+
+	// a ping payload like a hit id with a long --run-id and --node is too
+	// much for a control frame, and nothing goes out
+	longID := strings.Repeat("loadtest-run-", 8) + "." + strings.Repeat("node", 8) + ".17.42"
+	if err := c.WriteMessage(PingMessage, []byte(longID)); err == nil || !strings.Contains(err.Error(), "125") {
+		t.Errorf("a %d byte ping s/b an error, got %v", len(longID), err)
+	}
+
+	c.WriteMessage(PingMessage, []byte("p1"))
+	if op, got, err := c.ReadMessage(); op != PongMessage || string(got) != "p1" || err != nil {
+		t.Errorf("s/b a pong p1, got %d %q %v", op, got, err)
+	}
+
+	// the ping in the middle gets answered and doesn't get in the way
+	c.WriteMessage(TextMessage, []byte("fragments"))
+	if op, got, err := c.ReadMessage(); op != TextMessage || string(got) != "fragments" || err != nil {
+		t.Errorf("s/b put back together, got %d %q %v", op, got, err)
+	}
+	// the pong the server got for it comes back from the echo
+	if op, got, _ := c.ReadMessage(); op != PongMessage || string(got) != "in between" {
+		t.Errorf("s/b our pong echoed, got %d %q", op, got)
+	}
+
+	c.WriteMessage(TextMessage, []byte("bye"))
+	_, _, err = c.ReadMessage()
+	if closeErr, ok := err.(*CloseError); !ok || closeErr.Code != 1008 || closeErr.Reason != "busy" {
+		t.Errorf("s/b closed with 1008 busy, got %v", err)
+	}
+	if err := c.WriteMessage(TextMessage, []byte("x")); err == nil {
+		t.Errorf("writing after the close s/b an error")
+	}
+}
+
+func TestHandshakeErrors(t *testing.T) {
+	srv := echoServer(t)
+	defer srv.Close()
+
+	_, resp, err := dial(t, srv, "/", http.Header{"X-Token": {"wrong"}})
+	if err == nil || resp == nil || resp.StatusCode != 403 {
+		t.Errorf("s/b turned down with a 403, got %v %v", resp, err)
+	}
+	if _, _, err := dial(t, srv, "/?badkey=1", nil); err == nil || !strings.Contains(err.Error(), "Accept") {
+		t.Errorf("wrong accept key s/b an error, got %v", err)
+	}
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	if _, _, err := Dial(context.Background(), req, nil, nil); err == nil {
+		t.Errorf("http:// url s/b an error")
+	}
+
+	// a server that never answers, the ctx gives up on it
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			time.Sleep(5 * time.Second)
+			conn.Close()
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ = http.NewRequest("GET", "ws://"+l.Addr().String()+"/", nil)
+	if _, _, err := Dial(ctx, req, nil, nil); err != context.DeadlineExceeded {
+		t.Errorf("s/b the ctx's error, got %v", err)
+	}
+}
+
+func TestCounts(t *testing.T) {
+	c := NewCounts()
+	if c.Stats() != nil {
+		t.Errorf("Stats s/b nil before any connects")
+	}
+	c.Connected(10 * time.Millisecond)
+	c.Connected(30 * time.Millisecond)
+	c.ConnectFailed()
+	c.Sent()
+	c.Received()
+	c.Received()
+	c.Closed(true)
+	s := c.Stats()
+	if s.Open != 1 || s.Connects != 2 || s.ConnectFails != 1 || s.Disconnects != 1 {
+		t.Errorf("connection counts wrong, got %+v", s)
+	}
+	if s.ConnectAvgMs != 20 || s.ConnectMaxMs != 30 || s.Sent != 1 || s.Received != 2 {
+		t.Errorf("times or messages wrong, got %+v", s)
+	}
+}
+
+func TestStuckServer(t *testing.T) {
+	// does the handshake and then never reads or writes anything
+	hold := make(chan struct{})
+	defer close(hold)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		defer conn.Close()
+		conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " + acceptKey(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n"))
+		<-hold
+	}))
+	defer srv.Close()
+
+	// the writes fill up the socket buffers and then run out of time
+	c, _, err := dial(t, srv, "/", nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	c.WriteTimeout = 100 * time.Millisecond
+	big := bytes.Repeat([]byte("x"), 1<<20)
+	t0 := time.Now()
+	for err == nil && time.Since(t0) < 5*time.Second {
+		err = c.WriteMessage(BinaryMessage, big)
+	}
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("s/b a write timeout, got %v", err)
+	}
+	start := time.Now()
+	c.Close()
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("Close s/b quick even when the server isn't reading, took %v", took)
+	}
+
+	// cancelling the ctx after the handshake still hangs up
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequest("GET", "ws"+strings.TrimPrefix(srv.URL, "http")+"/", nil)
+	c, _, err = Dial(ctx, req, nil, nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	readErr := make(chan error)
+	go func() {
+		_, _, err := c.ReadMessage()
+		readErr <- err
+	}()
+	cancel()
+	select {
+	case err := <-readErr:
+		if err == nil {
+			t.Errorf("ReadMessage s/b an error after the cancel")
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("ReadMessage s/b done once the ctx is cancelled")
+	}
+}